@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -47,11 +48,249 @@ type Tls struct {
 	RelayExtraIpAddresses []string `json:"extraIpAddresses,omitempty"`
 }
 
+// ValuesReference references a key of a ConfigMap or Secret, in the namespace of
+// the KubeArmorConfig, holding helm values in YAML format
+type ValuesReference struct {
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// +kubebuilder:validation:optional
+	// +kubebuilder:default:=values.yaml
+	Key string `json:"key,omitempty"`
+	// Optional references do not fail the reconciliation if missing
+	// +kubebuilder:validation:optional
+	Optional bool `json:"optional,omitempty"`
+}
+
+// ValuesListMerge makes a list in helm values merge with the list of lower
+// precedence values, including chart defaults, instead of replacing it
+type ValuesListMerge struct {
+	// Path of the list in helm values, e.g. volumes.common
+	Path string `json:"path"`
+	// Key identifying list items, lists of scalar values are merged as a set
+	// +kubebuilder:validation:optional
+	// +kubebuilder:default:=name
+	Key string `json:"key,omitempty"`
+}
+
+// PatchTarget selects the rendered KubeArmor resources a patch applies to, empty
+// fields match any value
+type PatchTarget struct {
+	// +kubebuilder:validation:optional
+	Group string `json:"group,omitempty"`
+	// +kubebuilder:validation:optional
+	Version string `json:"version,omitempty"`
+	// +kubebuilder:validation:optional
+	Kind string `json:"kind,omitempty"`
+	// +kubebuilder:validation:optional
+	Name string `json:"name,omitempty"`
+	// +kubebuilder:validation:optional
+	Namespace string `json:"namespace,omitempty"`
+	// +kubebuilder:validation:optional
+	LabelSelector string `json:"labelSelector,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=strategic;json6902
+type PatchType string
+
+const (
+	// StrategicMergePatch patches resources with a strategic merge patch, JSON
+	// merge patch is used for kinds without strategic merge support
+	StrategicMergePatch PatchType = "strategic"
+	// JSON6902Patch patches resources with a RFC 6902 JSON patch
+	JSON6902Patch PatchType = "json6902"
+)
+
+// Patch is applied to the rendered KubeArmor manifests before they are applied
+type Patch struct {
+	Target PatchTarget `json:"target"`
+	// +kubebuilder:validation:optional
+	// +kubebuilder:default:=strategic
+	Type PatchType `json:"type,omitempty"`
+	// Patch content in YAML or JSON
+	Patch string `json:"patch"`
+}
+
+// +kubebuilder:validation:Enum=AllAtOnce;Canary
+type RolloutType string
+
+const (
+	// AllAtOnceRollout updates all KubeArmor daemonsets with the release
+	AllAtOnceRollout RolloutType = "AllAtOnce"
+	// CanaryRollout updates one KubeArmor daemonset, i.e. one node configuration
+	// group, at a time and only proceeds while the updated pods are healthy
+	CanaryRollout RolloutType = "Canary"
+)
+
+// +kubebuilder:validation:Enum=Pause;Rollback
+type RolloutFailurePolicy string
+
+const (
+	// PauseOnFailure stops the rollout, daemonsets not updated yet keep running
+	// the previous release until the spec changes
+	PauseOnFailure RolloutFailurePolicy = "Pause"
+	// RollbackOnFailure rolls the release back to the previous revision and
+	// restarts the updated daemonsets with it
+	RollbackOnFailure RolloutFailurePolicy = "Rollback"
+)
+
+// AlertRateGate fails a canary step when a Prometheus instant query exceeds a
+// bound, e.g. the rate of KubeArmor alerts on the updated nodes
+type AlertRateGate struct {
+	// PrometheusURL is the base URL of the Prometheus HTTP API
+	PrometheusURL string `json:"prometheusURL"`
+	// Query is evaluated once the updated pods are stable, $daemonset is
+	// replaced with the name of the updated daemonset. An empty result is 0
+	Query string `json:"query"`
+	// Max is the highest accepted query result
+	// +kubebuilder:validation:Pattern=`^[0-9]+(\.[0-9]+)?$`
+	Max string `json:"max"`
+}
+
+// MaintenanceWindow is a recurring period in which release changes are applied
+type MaintenanceWindow struct {
+	// Schedule of the window starts in cron format: minute hour day-of-month
+	// month day-of-week, e.g. "0 1 * * 1-5" for 1am on weekdays
+	Schedule string `json:"schedule"`
+	// Duration of the window, at most 168h
+	Duration metav1.Duration `json:"duration"`
+	// TimeZone of the schedule, e.g. Europe/Berlin, UTC if empty
+	// +kubebuilder:validation:optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// RolloutStrategy controls how release changes reach the KubeArmor daemonsets
+type RolloutStrategy struct {
+	// +kubebuilder:validation:optional
+	// +kubebuilder:default:=AllAtOnce
+	Type RolloutType `json:"type,omitempty"`
+	// StabilizationSeconds the pods of an updated daemonset must stay healthy
+	// after becoming ready before the next daemonset is updated
+	// +kubebuilder:validation:optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default:=60
+	StabilizationSeconds int32 `json:"stabilizationSeconds,omitempty"`
+	// ProgressDeadlineSeconds for the pods of an updated daemonset to become ready
+	// +kubebuilder:validation:optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default:=600
+	ProgressDeadlineSeconds int32 `json:"progressDeadlineSeconds,omitempty"`
+	// MaxRestarts of a container of the updated pods, 0 tolerates no restart.
+	// Crash looping containers always fail the step
+	// +kubebuilder:validation:optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default:=3
+	MaxRestarts int32 `json:"maxRestarts,omitempty"`
+	// AlertRate optionally bounds a Prometheus query, e.g. the alert rate
+	// +kubebuilder:validation:optional
+	AlertRate *AlertRateGate `json:"alertRate,omitempty"`
+	// +kubebuilder:validation:optional
+	// +kubebuilder:default:=Pause
+	OnFailure RolloutFailurePolicy `json:"onFailure,omitempty"`
+}
+
+// NodeProfile overrides the KubeArmor configuration of a pool of nodes. Nodes
+// selected by the profile are labeled kubearmor.io/profile=<name> and run
+// KubeArmor from a copy of each daemonset named after the profile
+type NodeProfile struct {
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=20
+	Name string `json:"name"`
+	// NodeSelector selects the nodes of the profile by their labels
+	NodeSelector metav1.LabelSelector `json:"nodeSelector"`
+	// +kubebuilder:validation:optional
+	DefaultFilePosture PostureType `json:"defaultFilePosture,omitempty"`
+	// +kubebuilder:validation:optional
+	DefaultCapabilitiesPosture PostureType `json:"defaultCapabilitiesPosture,omitempty"`
+	// +kubebuilder:validation:optional
+	DefaultNetworkPosture PostureType `json:"defaultNetworkPosture,omitempty"`
+	// +kubebuilder:validation:optional
+	DefaultVisibility string `json:"defaultVisibility,omitempty"`
+	// +kubebuilder:validation:optional
+	KubeArmorImage ImageSpec `json:"kubearmorImage,omitempty"`
+	// Args are appended to the arguments of the KubeArmor container
+	// +kubebuilder:validation:optional
+	Args []string `json:"args,omitempty"`
+}
+
+// LSM is a Linux security module KubeArmor enforces policies with
+// +kubebuilder:validation:Enum=bpf;apparmor;selinux
+type LSM string
+
+// KubeArmorArgs are flags of the KubeArmor daemon, passed as arguments of the
+// KubeArmor container. Fields left empty keep the KubeArmor defaults
+type KubeArmorArgs struct {
+	// LSMOrder is the order of preference of the LSMs used for enforcement
+	// +kubebuilder:validation:optional
+	LSMOrder []LSM `json:"lsmOrder,omitempty"`
+	// EnableKubeArmorPolicy enforces KubeArmorPolicies, enabled by KubeArmor
+	// unless set to false
+	// +kubebuilder:validation:optional
+	EnableKubeArmorPolicy *bool `json:"enableKubeArmorPolicy,omitempty"`
+	// DefaultPostureLogs reports events of the default posture
+	// +kubebuilder:validation:optional
+	DefaultPostureLogs *bool `json:"defaultPostureLogs,omitempty"`
+	// UntrackedNamespaces are not monitored by KubeArmor
+	// +kubebuilder:validation:optional
+	UntrackedNamespaces []string `json:"untrackedNamespaces,omitempty"`
+	// Debug enables debug logs
+	// +kubebuilder:validation:optional
+	Debug bool `json:"debug,omitempty"`
+	// ExtraArgs are appended to the arguments generated from the other fields,
+	// as -flag or -flag=value
+	// +kubebuilder:validation:optional
+	// +kubebuilder:validation:items:Pattern=`^--?[A-Za-z][A-Za-z0-9.]*(=.*)?$`
+	ExtraArgs []string `json:"extraArgs,omitempty"`
+	// AllowUnknownFlags passes flags unknown to the operator in extraArgs, the
+	// kubearmor.args value and node profile arguments instead of rejecting them
+	// +kubebuilder:validation:optional
+	AllowUnknownFlags bool `json:"allowUnknownFlags,omitempty"`
+}
+
+// HostSecurity configures the protection and monitoring of the nodes
+// themselves with KubeArmorHostPolicies
+type HostSecurity struct {
+	// Enable enforces KubeArmorHostPolicies
+	// +kubebuilder:validation:optional
+	Enable bool `json:"enable,omitempty"`
+	// +kubebuilder:validation:optional
+	DefaultFilePosture PostureType `json:"defaultFilePosture,omitempty"`
+	// +kubebuilder:validation:optional
+	DefaultCapabilitiesPosture PostureType `json:"defaultCapabilitiesPosture,omitempty"`
+	// +kubebuilder:validation:optional
+	DefaultNetworkPosture PostureType `json:"defaultNetworkPosture,omitempty"`
+	// Visibility of host process, file, network and capabilities events,
+	// none disables it
+	// +kubebuilder:validation:optional
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// NamespacePosture sets the default postures and visibility of the namespaces
+// it selects with the namespace annotations read by KubeArmor
+type NamespacePosture struct {
+	// NamespaceSelector selects namespaces by their labels, an empty selector
+	// selects all namespaces
+	// +kubebuilder:validation:optional
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// +kubebuilder:validation:optional
+	FilePosture PostureType `json:"filePosture,omitempty"`
+	// +kubebuilder:validation:optional
+	CapabilitiesPosture PostureType `json:"capabilitiesPosture,omitempty"`
+	// +kubebuilder:validation:optional
+	NetworkPosture PostureType `json:"networkPosture,omitempty"`
+	// +kubebuilder:validation:optional
+	Visibility string `json:"visibility,omitempty"`
+}
+
 // KubeArmorConfigSpec defines the desired state of KubeArmorConfig
 type KubeArmorConfigSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
+	// Version of the KubeArmor chart to deploy. When the operator uses the
+	// embedded charts it must be one of the embedded chart versions
+	// +kubebuilder:validation:optional
+	Version string `json:"version,omitempty"`
 	// +kubebuilder:validation:optional
 	DefaultFilePosture PostureType `json:"defaultFilePosture,omitempty"`
 	// +kubebuilder:validation:optional
@@ -86,6 +325,55 @@ type KubeArmorConfigSpec struct {
 	MaxAlertPerSec int `json:"maxAlertPerSec,omitempty"`
 	// +kubebuilder:validation:Optional
 	ThrottleSec int `json:"throttleSec,omitempty"`
+	// KubeArmorArgs configures flags of the KubeArmor daemon, they are checked
+	// against the KubeArmor version of the image or chart
+	// +kubebuilder:validation:Optional
+	KubeArmorArgs KubeArmorArgs `json:"kubearmorArgs,omitempty"`
+	// HostSecurity enables KubeArmorHostPolicies and sets the default host
+	// postures and visibility
+	// +kubebuilder:validation:Optional
+	HostSecurity HostSecurity `json:"hostSecurity,omitempty"`
+	// ValuesFrom lists ConfigMaps and Secrets holding raw helm values. They are
+	// merged in order over the values generated from this spec
+	// +kubebuilder:validation:Optional
+	ValuesFrom []ValuesReference `json:"valuesFrom,omitempty"`
+	// Values holds raw helm values merged over the values generated from this
+	// spec and valuesFrom. Node configuration values are managed by the operator
+	// and can not be overridden
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Values *apiextensionsv1.JSON `json:"values,omitempty"`
+	// ValuesListMerge lists the helm values lists to be merged by key rather
+	// than replaced. A null value in values removes the key from chart defaults
+	// +kubebuilder:validation:Optional
+	ValuesListMerge []ValuesListMerge `json:"valuesListMerge,omitempty"`
+	// Patches are applied in order to the rendered manifests, they allow changes
+	// not expressible as chart values
+	// +kubebuilder:validation:Optional
+	Patches []Patch `json:"patches,omitempty"`
+	// Rollout strategy of release changes to the KubeArmor daemonsets
+	// +kubebuilder:validation:Optional
+	Rollout *RolloutStrategy `json:"rollout,omitempty"`
+	// Paused stops applying changes to the release, including node
+	// configuration changes. The paused annotation has the same effect
+	// +kubebuilder:validation:Optional
+	Paused bool `json:"paused,omitempty"`
+	// MaintenanceWindows restrict when changes are applied to the release,
+	// changes outside of them are pending until the next window starts
+	// +kubebuilder:validation:Optional
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty"`
+	// NodeProfiles override the KubeArmor configuration on the nodes they
+	// select with dedicated daemonsets, nodes selected by several profiles use
+	// the first one
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	NodeProfiles []NodeProfile `json:"nodeProfiles,omitempty"`
+	// NamespacePostures annotate the namespaces they select with default
+	// postures and visibility, namespaces selected by several entries use the
+	// first one
+	// +kubebuilder:validation:Optional
+	NamespacePostures []NamespacePosture `json:"namespacePostures,omitempty"`
 }
 
 // KubeArmorConfigStatus defines the observed state of KubeArmorConfig
@@ -96,6 +384,147 @@ type KubeArmorConfigStatus struct {
 	Phase string `json:"phase,omitempty"`
 	// +kubebuilder:validation:optional
 	Message string `json:"message,omitempty"`
+	// Preflight reports the KubeArmor compatibility of the cluster nodes
+	// +kubebuilder:validation:optional
+	Preflight *PreflightStatus `json:"preflight,omitempty"`
+	// Rollout reports the progress of canary rollouts
+	// +kubebuilder:validation:optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+	// Pending reports changes not applied to the release yet
+	// +kubebuilder:validation:optional
+	Pending *PendingStatus `json:"pending,omitempty"`
+	// Upgrade reports the progress of the latest release upgrade
+	// +kubebuilder:validation:optional
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+}
+
+// kubearmorconfig phases, only one kubearmorconfig configures the release
+const (
+	// ConfigActive is the phase of the kubearmorconfig the release is made from
+	ConfigActive = "Active"
+	// ConfigIgnored is the phase of all other kubearmorconfigs
+	ConfigIgnored = "Ignored"
+)
+
+// release upgrade phases
+const (
+	UpgradeRunning   = "Running"
+	UpgradeSucceeded = "Succeeded"
+	UpgradeFailed    = "Failed"
+	UpgradeDeferred  = "Deferred"
+)
+
+// UpgradeStatus reports the progress of a release upgrade, upgrades run in the
+// background and requests made while one is running are coalesced into the next
+type UpgradeStatus struct {
+	// +kubebuilder:validation:Enum=Running;Succeeded;Failed;Deferred
+	Phase string `json:"phase"`
+	// Revision of the release deployed by the upgrade
+	// +kubebuilder:validation:optional
+	Revision int `json:"revision,omitempty"`
+	// StartTime of the upgrade
+	// +kubebuilder:validation:optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime of the upgrade
+	// +kubebuilder:validation:optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Reasons the upgrade was requested for
+	// +kubebuilder:validation:optional
+	Reasons []string `json:"reasons,omitempty"`
+	// +kubebuilder:validation:optional
+	Message string `json:"message,omitempty"`
+}
+
+// PendingStatus reports why changes are not applied to the release
+type PendingStatus struct {
+	// Reason changes are pending, paused or outside of the maintenance windows
+	Reason string `json:"reason"`
+	// Since when changes are pending
+	Since metav1.Time `json:"since"`
+	// NextWindow is the start of the next maintenance window, unset if paused
+	// +kubebuilder:validation:optional
+	NextWindow *metav1.Time `json:"nextWindow,omitempty"`
+}
+
+// canary rollout phases
+const (
+	RolloutProgressing = "Progressing"
+	RolloutPaused      = "Paused"
+	RolloutRollingBack = "RollingBack"
+	RolloutRolledBack  = "RolledBack"
+	RolloutCompleted   = "Completed"
+)
+
+// RolloutStatus reports the progress of the canary rollout of a release revision
+type RolloutStatus struct {
+	// Revision of the release being rolled out
+	Revision int `json:"revision"`
+	// ObservedGeneration of the kubearmorconfig the rollout started with
+	// +kubebuilder:validation:optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +kubebuilder:validation:Enum=Progressing;Paused;RollingBack;RolledBack;Completed
+	Phase string `json:"phase"`
+	// UpdatedDaemonSets have been restarted with the revision
+	// +kubebuilder:validation:optional
+	UpdatedDaemonSets []string `json:"updatedDaemonSets,omitempty"`
+	// CurrentDaemonSet is being updated
+	// +kubebuilder:validation:optional
+	CurrentDaemonSet string `json:"currentDaemonSet,omitempty"`
+	// StartTime of the update of the current daemonset
+	// +kubebuilder:validation:optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// ReadyTime at which the pods of the current daemonset became ready
+	// +kubebuilder:validation:optional
+	ReadyTime *metav1.Time `json:"readyTime,omitempty"`
+	// +kubebuilder:validation:optional
+	Message string `json:"message,omitempty"`
+}
+
+// KubeArmor modes on a node
+const (
+	// NodeModeEnforce nodes have an LSM KubeArmor enforces policies with
+	NodeModeEnforce = "enforce"
+	// NodeModeAudit nodes have no supported LSM, policy violations are only audited
+	NodeModeAudit = "audit"
+	// NodeModeNone nodes are not selected by any KubeArmor daemonset
+	NodeModeNone = "none"
+)
+
+// PreflightStatus summarizes the KubeArmor compatibility of the cluster nodes
+type PreflightStatus struct {
+	// EnforceNodes is the number of nodes KubeArmor enforces policies on
+	EnforceNodes int `json:"enforceNodes"`
+	// AuditNodes is the number of nodes KubeArmor only audits on
+	AuditNodes int `json:"auditNodes"`
+	// UnmatchedNodes is the number of linux nodes no KubeArmor daemonset would select
+	UnmatchedNodes int `json:"unmatchedNodes"`
+	// Nodes lists the nodes that need attention, nodes in audit mode or not
+	// selected by any daemonset, capped to keep the status small
+	// +kubebuilder:validation:optional
+	Nodes []NodePreflight `json:"nodes,omitempty"`
+	// LastUpdateTime of the preflight report
+	// +kubebuilder:validation:optional
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// NodePreflight reports the configuration detected by snitch on a node and how
+// KubeArmor would run on it
+type NodePreflight struct {
+	Name          string `json:"name"`
+	Enforcer      string `json:"enforcer,omitempty"`
+	BTF           string `json:"btf,omitempty"`
+	Runtime       string `json:"runtime,omitempty"`
+	Socket        string `json:"socket,omitempty"`
+	Arch          string `json:"arch,omitempty"`
+	KernelVersion string `json:"kernelVersion,omitempty"`
+	// Mode KubeArmor runs in on the node
+	// +kubebuilder:validation:Enum=enforce;audit;none
+	Mode string `json:"mode"`
+	// Matched reports whether a KubeArmor daemonset would select the node
+	Matched bool `json:"matched"`
+	// Issues found on the node
+	// +kubebuilder:validation:optional
+	Issues []string `json:"issues,omitempty"`
 }
 
 // KubeArmorConfig is the Schema for the kubearmorconfigs API
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+// bundle formats
+const (
+	formatYAML      = "yaml"
+	formatDir       = "dir"
+	formatKustomize = "kustomize"
+)
+
+// bundleManifest is a single rendered resource
+type bundleManifest struct {
+	kind    string
+	name    string
+	content string
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// fileName returns the file name of the manifest, prefixed with its position in
+// the install order so that listing the directory preserves it
+func (m bundleManifest) fileName(i int) string {
+	name := strings.ToLower(m.kind + "-" + m.name)
+	return fmt.Sprintf("%03d-%s.yaml", i, strings.Trim(unsafeFileChars.ReplaceAllString(name, "-"), "-"))
+}
+
+// bundleManifests splits the release into resources in helm install order,
+// followed by the hooks which keep their helm hook annotations
+func bundleManifests(rel *release.Release) ([]bundleManifest, error) {
+	_, manifests, err := releaseutil.SortManifests(releaseutil.SplitManifests(rel.Manifest), nil, releaseutil.InstallOrder)
+	if err != nil {
+		return nil, fmt.Errorf("error sorting rendered manifests: %s", err.Error())
+	}
+	out := []bundleManifest{}
+	for _, m := range manifests {
+		if m.Head == nil || m.Head.Metadata == nil {
+			// empty document
+			continue
+		}
+		out = append(out, bundleManifest{
+			kind:    m.Head.Kind,
+			name:    m.Head.Metadata.Name,
+			content: m.Content,
+		})
+	}
+	for _, h := range rel.Hooks {
+		out = append(out, bundleManifest{
+			kind:    h.Kind,
+			name:    h.Name,
+			content: h.Manifest,
+		})
+	}
+	return out, nil
+}
+
+// writeBundle writes the rendered release in the given format, output is a file
+// for the yaml format, "-" or empty for stdout, and a directory otherwise
+func writeBundle(rel *release.Release, format, output string) error {
+	manifests, err := bundleManifests(rel)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case formatYAML:
+		if output == "" || output == "-" {
+			return writeYAML(os.Stdout, manifests)
+		}
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		if err := writeYAML(f, manifests); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case formatDir, formatKustomize:
+		if output == "" || output == "-" {
+			return fmt.Errorf("an output directory is required for the %s format", format)
+		}
+		return writeDir(output, manifests, format == formatKustomize)
+	}
+	return fmt.Errorf("unsupported format %q, use one of %s, %s, %s", format, formatYAML, formatDir, formatKustomize)
+}
+
+func writeYAML(w io.Writer, manifests []bundleManifest) error {
+	for _, m := range manifests {
+		if _, err := fmt.Fprintf(w, "---\n%s\n", strings.TrimSpace(m.content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDir(dir string, manifests []bundleManifest, kustomization bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// remove manifests of a previous render, resources may have been removed since
+	previous, err := filepath.Glob(filepath.Join(dir, "[0-9][0-9][0-9]-*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, f := range append(previous, filepath.Join(dir, "kustomization.yaml")) {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	resources := []string{}
+	for i, m := range manifests {
+		name := m.fileName(i)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.TrimSpace(m.content)+"\n"), 0644); err != nil {
+			return err
+		}
+		resources = append(resources, name)
+	}
+	if !kustomization {
+		return nil
+	}
+	data, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  resources,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "kustomization.yaml"), data, 0644)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+var testRelease = &release.Release{
+	Manifest: `---
+# Source: kubearmor/templates/daemonset.yaml
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: kubearmor-bpf-containerd-98c2c
+---
+# Source: kubearmor/templates/serviceaccount.yaml
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: kubearmor
+---
+`,
+	Hooks: []*release.Hook{{
+		Name:     "kubearmor-cleanup",
+		Kind:     "Job",
+		Path:     "kubearmor/templates/hooks.yaml",
+		Manifest: "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: kubearmor-cleanup\n  annotations:\n    helm.sh/hook: pre-delete\n",
+	}},
+}
+
+func TestWriteBundle(t *testing.T) {
+	dir := t.TempDir()
+
+	// stale manifests of a previous render are removed
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "003-configmap-stale.yaml"), []byte("stale"), 0644))
+
+	assert.NoError(t, writeBundle(testRelease, formatKustomize, dir))
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	assert.NoError(t, err)
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	// resources are written in helm install order followed by hooks
+	assert.Equal(t, []string{
+		"000-serviceaccount-kubearmor.yaml",
+		"001-daemonset-kubearmor-bpf-containerd-98c2c.yaml",
+		"002-job-kubearmor-cleanup.yaml",
+		"kustomization.yaml",
+	}, files)
+
+	kustomization, err := os.ReadFile(filepath.Join(dir, "kustomization.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+- 000-serviceaccount-kubearmor.yaml
+- 001-daemonset-kubearmor-bpf-containerd-98c2c.yaml
+- 002-job-kubearmor-cleanup.yaml
+`, string(kustomization))
+
+	out := filepath.Join(t.TempDir(), "kubearmor.yaml")
+	assert.NoError(t, writeBundle(testRelease, formatYAML, out))
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "---\n# Source: kubearmor/templates/serviceaccount.yaml\napiVersion: v1\nkind: ServiceAccount")
+	assert.Contains(t, string(data), "helm.sh/hook: pre-delete")
+
+	assert.Error(t, writeBundle(testRelease, formatDir, "-"))
+	assert.Error(t, writeBundle(testRelease, "json", out))
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/controller"
+)
+
+// manifests returns the release manifests followed by its hooks
+func manifests(rel *release.Release) string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(rel.Manifest))
+	b.WriteString("\n")
+	for _, hook := range rel.Hooks {
+		fmt.Fprintf(&b, "---\n# Source: %s\n%s\n", hook.Path, strings.TrimSpace(hook.Manifest))
+	}
+	return b.String()
+}
+
+func runRender(ctx context.Context, args []string) error {
+	fs, o := newFlagSet("render")
+	o.addValuesFlags(fs)
+	var format, output string
+	fs.StringVar(&format, "format", formatYAML,
+		"Output format: yaml for a single multi-document file, dir for a directory of manifests, kustomize for a directory with a kustomization")
+	fs.StringVar(&output, "output", "-", "Output file for the yaml format, output directory otherwise")
+	fs.BoolVar(&o.offline, "offline", false,
+		"Render the embedded chart without cluster access, requires --node-config and no valuesFrom")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	helmController, err := o.configuredHelmController(ctx)
+	if err != nil {
+		return err
+	}
+	rel, err := helmController.Render(ctx)
+	if err != nil {
+		return err
+	}
+	return writeBundle(rel, format, output)
+}
+
+func runDiff(ctx context.Context, args []string) error {
+	fs, o := newFlagSet("diff")
+	o.addValuesFlags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	helmController, err := o.configuredHelmController(ctx)
+	if err != nil {
+		return err
+	}
+	rendered, err := helmController.Render(ctx)
+	if err != nil {
+		return err
+	}
+	deployed := ""
+	rel, err := helmController.Release()
+	if err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return err
+	}
+	deployedName := "deployed"
+	if err == nil {
+		deployed = manifests(rel)
+		deployedName = fmt.Sprintf("deployed (revision %d)", rel.Version)
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(deployed),
+		B:        difflib.SplitLines(manifests(rendered)),
+		FromFile: deployedName,
+		ToFile:   "rendered",
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Print(diff)
+	return nil
+}
+
+func runInstall(ctx context.Context, args []string) error {
+	return deploy(ctx, "install", args)
+}
+
+func runUpgrade(ctx context.Context, args []string) error {
+	return deploy(ctx, "upgrade", args)
+}
+
+// deploy installs or upgrades the release, the release must be absent for
+// install and present for upgrade
+func deploy(ctx context.Context, name string, args []string) error {
+	fs, o := newFlagSet(name)
+	o.addValuesFlags(fs)
+	fs.BoolVar(&o.adopt, "adopt-legacy-resources", true,
+		"Take over the resources of KubeArmor installations older than v1.3.8 instead of deleting them")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	helmController, err := o.configuredHelmController(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = helmController.Release()
+	switch {
+	case err != nil && !errors.Is(err, driver.ErrReleaseNotFound):
+		return err
+	case err == nil && name == "install":
+		return fmt.Errorf("release %s is already installed, use upgrade", o.chart.Name)
+	case err != nil && name == "upgrade":
+		return fmt.Errorf("release %s is not installed, use install", o.chart.Name)
+	}
+	if err := helmController.Preinstall(); err != nil {
+		return fmt.Errorf("error migrating previous installation: %s", err.Error())
+	}
+	rel, err := helmController.UpgradeRelease(ctx)
+	if err != nil {
+		return err
+	}
+	printRelease(rel)
+	return nil
+}
+
+func runUninstall(ctx context.Context, args []string) error {
+	fs, o := newFlagSet("uninstall")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	helmController, err := o.helmController()
+	if err != nil {
+		return err
+	}
+	if err := helmController.UninstallRelease(); err != nil {
+		return err
+	}
+	fmt.Printf("release %s uninstalled\n", o.chart.Name)
+	return nil
+}
+
+func runStatus(ctx context.Context, args []string) error {
+	fs, o := newFlagSet("status")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	helmController, err := o.helmController()
+	if err != nil {
+		return err
+	}
+	rel, err := helmController.Release()
+	if err != nil {
+		return err
+	}
+	printRelease(rel)
+	return nil
+}
+
+func printRelease(rel *release.Release) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "NAME:\t%s\n", rel.Name)
+	fmt.Fprintf(w, "NAMESPACE:\t%s\n", rel.Namespace)
+	fmt.Fprintf(w, "REVISION:\t%d\n", rel.Version)
+	fmt.Fprintf(w, "STATUS:\t%s\n", rel.Info.Status)
+	fmt.Fprintf(w, "CHART:\t%s-%s\n", rel.Chart.Metadata.Name, rel.Chart.Metadata.Version)
+	fmt.Fprintf(w, "APP VERSION:\t%s\n", rel.Chart.Metadata.AppVersion)
+	fmt.Fprintf(w, "LAST DEPLOYED:\t%s\n", rel.Info.LastDeployed.Format("Mon Jan _2 15:04:05 2006"))
+	if rel.Info.Description != "" {
+		fmt.Fprintf(w, "DESCRIPTION:\t%s\n", rel.Info.Description)
+	}
+	w.Flush()
+}
+
+func runNodes(ctx context.Context, args []string) error {
+	fs, o := newFlagSet("nodes")
+	var output string
+	fs.StringVar(&output, "o", "", "Output format, \"yaml\" prints the node configurations for --node-config")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	clientset, _, err := o.clients()
+	if err != nil {
+		return err
+	}
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	switch output {
+	case "yaml":
+		data, err := yaml.Marshal(controller.DetectedNodeConfigs(nodes.Items))
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+	case "":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "NODE\tENFORCER\tRUNTIME\tSOCKET\tARCH\tBTF\tAPPARMORFS\tSECCOMP")
+		for _, node := range nodes.Items {
+			if node.Labels[defaults.OsLabel] != "linux" {
+				continue
+			}
+			if node.Labels[defaults.RandLabel] == "" {
+				fmt.Fprintf(w, "%s\t<not detected>\t\t\t\t\t\t\n", node.Name)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", node.Name,
+				node.Labels[defaults.EnforcerLabel], node.Labels[defaults.RuntimeLabel],
+				node.Labels[defaults.SocketLabel], node.Labels[defaults.ArchLabel],
+				node.Labels[defaults.BTFLabel], node.Labels[defaults.ApparmorFsLabel],
+				node.Labels[defaults.SeccompLabel])
+		}
+		w.Flush()
+	default:
+		return fmt.Errorf("unsupported output format %q", output)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/controller"
+)
+
+func runDoctor(ctx context.Context, args []string) error {
+	fs, o := newFlagSet("doctor")
+	var output string
+	var probe bool
+	var probeTimeout time.Duration
+	fs.StringVar(&output, "o", "", "Output format, \"yaml\" prints the node reports")
+	fs.BoolVar(&probe, "probe", false, "Run snitch on linux nodes whose configuration has not been detected yet")
+	fs.DurationVar(&probeTimeout, "probe-timeout", 3*time.Minute, "Time to wait for snitch to label probed nodes")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	clientset, _, err := o.clients()
+	if err != nil {
+		return err
+	}
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	if probe {
+		if nodes, err = probeNodes(ctx, clientset, o.namespace, nodes.Items, probeTimeout); err != nil {
+			return err
+		}
+	}
+
+	reports := controller.Preflight(nodes.Items)
+	switch output {
+	case "yaml":
+		data, err := yaml.Marshal(reports)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+	case "":
+		printPreflight(reports)
+	default:
+		return fmt.Errorf("unsupported output format %q", output)
+	}
+
+	if summary := controller.PreflightSummary(reports); summary.UnmatchedNodes > 0 {
+		return fmt.Errorf("KubeArmor would not run on %d of %d linux nodes", summary.UnmatchedNodes, len(reports))
+	}
+	return nil
+}
+
+// probeNodes runs snitch on the linux nodes it has not labelled yet and waits
+// until they are labelled, or the timeout expires
+func probeNodes(ctx context.Context, clientset *kubernetes.Clientset, namespace string, nodes []corev1.Node, timeout time.Duration) (*corev1.NodeList, error) {
+	pending := map[string]bool{}
+	for i := range nodes {
+		if nodes[i].Labels[defaults.OsLabel] != "linux" || nodes[i].Labels[defaults.RandLabel] != "" {
+			continue
+		}
+		if err := controller.RunSnitch(ctx, clientset, namespace, &nodes[i]); err != nil {
+			return nil, fmt.Errorf("unable to run snitch on node %s: %s", nodes[i].Name, err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "probing node %s\n", nodes[i].Name)
+		pending[nodes[i].Name] = true
+	}
+
+	var list *corev1.NodeList
+	err := wait.PollUntilContextTimeout(ctx, 5*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		var err error
+		list, err = clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, nodeObj := range list.Items {
+			if pending[nodeObj.Name] && nodeObj.Labels[defaults.RandLabel] != "" {
+				delete(pending, nodeObj.Name)
+			}
+		}
+		return len(pending) == 0, nil
+	})
+	if err != nil && list == nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		fmt.Fprintf(os.Stderr, "snitch did not finish on %d nodes within %s\n", len(pending), timeout)
+	}
+	return list, nil
+}
+
+func printPreflight(reports []operatorv1.NodePreflight) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NODE\tMODE\tMATCHED\tENFORCER\tBTF\tRUNTIME\tSOCKET\tKERNEL\tISSUES")
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, r.Mode, r.Matched,
+			r.Enforcer, r.BTF, r.Runtime, r.Socket, r.KernelVersion, strings.Join(r.Issues, "; "))
+	}
+	w.Flush()
+}
@@ -0,0 +1,76 @@
+// kubearmor-operator is a command line interface to render and manage the
+// KubeArmor release outside of the cluster, using the same helm controller
+// and values as the in-cluster operator
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/go-logr/logr"
+)
+
+type command struct {
+	description string
+	run         func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"render":         {"Render the KubeArmor manifests the operator would apply", runRender},
+	"diff":           {"Show the difference between the deployed and the rendered manifests", runDiff},
+	"doctor":         {"Check whether KubeArmor can enforce policies on the cluster nodes", runDoctor},
+	"install":        {"Install the KubeArmor release", runInstall},
+	"upgrade":        {"Upgrade the installed KubeArmor release", runUpgrade},
+	"uninstall":      {"Uninstall the KubeArmor release", runUninstall},
+	"status":         {"Show the status of the KubeArmor release", runStatus},
+	"nodes":          {"Show the node configurations detected by snitch", runNodes},
+	"support-bundle": {"Collect a redacted support bundle for troubleshooting", runSupportBundle},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", filepath.Base(os.Args[0]))
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "-h" && os.Args[1] != "--help" && os.Args[1] != "help" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		}
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(ctrl.SetupSignalHandler(), os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// setupLogs routes helm and operator logs to stderr if verbose, they are
+// discarded otherwise
+func setupLogs(verbose bool) {
+	if verbose {
+		ctrl.SetLogger(zap.New(zap.WriteTo(os.Stderr)))
+		return
+	}
+	ctrl.SetLogger(logr.Discard())
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/config"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/controller"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/helm"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(operatorv1.AddToScheme(scheme))
+}
+
+// options are the flags shared by the commands
+type options struct {
+	kubeArmorConfigFile string
+	nodeConfigFile      string
+	namespace           string
+	chart               config.ChartConfig
+	timeout             time.Duration
+	wait                bool
+	storageDriver       string
+	maxHistory          int
+	verbose             bool
+	// offline renders the embedded chart without connecting to the cluster
+	offline bool
+	// adopt resources of legacy installations instead of deleting them
+	adopt bool
+
+	clientset *kubernetes.Clientset
+	client    client.Client
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	defaults := config.Default()
+	defaults.ApplyEnv()
+	o := &options{chart: defaults.Chart}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&o.namespace, "namespace", defaults.Namespace, "Namespace of the KubeArmor release")
+	fs.StringVar(&o.chart.Name, "chart", o.chart.Name, "Helm chart release name")
+	fs.StringVar(&o.chart.Repository, "repository", o.chart.Repository,
+		"The helm chart repository to pull the KubeArmor chart from, \"embed\" to use the embedded charts")
+	fs.StringVar(&o.chart.Version, "version", "", "The helm chart version of the KubeArmor to deploy")
+	fs.StringVar(&o.chart.Directory, "directory", "", "Path to chart directory if local chart is to be used")
+	fs.StringVar(&o.chart.CacheDir, "chart-cache-dir", helm.DefaultChartCacheDir, "Directory to cache pulled helm charts")
+	fs.DurationVar(&o.timeout, "timeout", defaults.Timeouts.HelmOperation.Duration, "Timeout of helm operations")
+	fs.BoolVar(&o.wait, "wait", defaults.Helm.Wait, "Wait for the release resources to become ready")
+	fs.StringVar(&o.storageDriver, "storage-driver", defaults.Helm.StorageDriver, "Helm storage driver, secret, configmap or sql")
+	fs.IntVar(&o.maxHistory, "max-history", defaults.Helm.MaxHistory, "Number of release revisions kept, 0 keeps all of them")
+	fs.BoolVar(&o.verbose, "verbose", false, "Log helm and operator actions to stderr")
+	return fs, o
+}
+
+// addValuesFlags registers the flags selecting the KubeArmorConfig and node
+// configurations the helm values are generated from
+func (o *options) addValuesFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.kubeArmorConfigFile, "f", "", "KubeArmorConfig YAML file (required)")
+	fs.StringVar(&o.nodeConfigFile, "node-config", "",
+		"YAML file of node configurations as printed by 'nodes -o yaml', live node labels are used if not set")
+}
+
+func (o *options) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	setupLogs(o.verbose)
+	if o.offline {
+		if o.chart.Directory == "" {
+			o.chart.Repository = "embed"
+		}
+		if o.nodeConfigFile == "" {
+			return fmt.Errorf("--node-config is required with --offline")
+		}
+	}
+	return nil
+}
+
+// clients connects to the cluster of the current kubeconfig context on first use
+func (o *options) clients() (*kubernetes.Clientset, client.Client, error) {
+	if o.clientset != nil {
+		return o.clientset, o.client, nil
+	}
+	if o.offline {
+		return nil, nil, fmt.Errorf("cluster access is disabled with --offline")
+	}
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load kubeconfig: %s", err.Error())
+	}
+	o.clientset, err = kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	o.client, err = client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, nil, err
+	}
+	return o.clientset, o.client, nil
+}
+
+// helmController initializes a helm controller without values
+func (o *options) helmController() (*helm.Controller, error) {
+	return helm.NewHelmController(helm.Config{
+		ChartName:            o.chart.Name,
+		Namespace:            o.namespace,
+		Version:              o.chart.Version,
+		Repository:           o.chart.Repository,
+		Directory:            o.chart.Directory,
+		CacheDir:             o.chart.CacheDir,
+		Timeout:              o.timeout,
+		Wait:                 o.wait,
+		StorageDriver:        o.storageDriver,
+		MaxHistory:           o.maxHistory,
+		AdoptLegacyResources: o.adopt,
+	})
+}
+
+// configuredHelmController initializes a helm controller with the helm values
+// generated from the KubeArmorConfig and node configurations, following the
+// same steps as the KubeArmorConfig reconciler and cluster watcher
+func (o *options) configuredHelmController(ctx context.Context) (*helm.Controller, error) {
+	if o.kubeArmorConfigFile == "" {
+		return nil, fmt.Errorf("a KubeArmorConfig file is required, set it with -f")
+	}
+	data, err := os.ReadFile(o.kubeArmorConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	kaConfig := &operatorv1.KubeArmorConfig{}
+	if err := yaml.UnmarshalStrict(data, kaConfig); err != nil {
+		return nil, fmt.Errorf("error parsing KubeArmorConfig %s: %s", o.kubeArmorConfigFile, err.Error())
+	}
+	if kaConfig.Namespace == "" {
+		kaConfig.Namespace = o.namespace
+	}
+
+	nodeConfigs, err := o.nodeConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader client.Reader
+	if len(kaConfig.Spec.ValuesFrom) > 0 {
+		_, c, err := o.clients()
+		if err != nil {
+			return nil, err
+		}
+		reader = c
+	}
+
+	helmController, err := o.helmController()
+	if err != nil {
+		return nil, err
+	}
+	if err := helmController.UseChartVersion(kaConfig.Spec.Version); err != nil {
+		return nil, err
+	}
+	userValues, err := controller.ResolveUserValues(ctx, reader, kaConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := helmController.ValidateKubeArmorArgs(kaConfig, userValues); err != nil {
+		return nil, err
+	}
+	helmController.UpdateHelmValuesFromKubeArmorConfig(kaConfig)
+	helmController.UpdateUserHelmValues(userValues)
+	helmController.UpdateNodeConfigHelmValues(controller.NodeConfigHelmValues(nodeConfigs))
+	return helmController, nil
+}
+
+// nodeConfigs reads node configurations from the node config file, or from the
+// labels snitch has set on the cluster nodes
+func (o *options) nodeConfigs(ctx context.Context) ([]map[string]interface{}, error) {
+	if o.nodeConfigFile != "" {
+		data, err := os.ReadFile(o.nodeConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		return controller.ParseNodeConfigs(data)
+	}
+	clientset, _, err := o.clients()
+	if err != nil {
+		return nil, fmt.Errorf("%s, set --node-config to run without a cluster", err.Error())
+	}
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return controller.DetectedNodeConfigs(nodes.Items), nil
+}
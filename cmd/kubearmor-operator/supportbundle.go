@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/supportbundle"
+)
+
+func runSupportBundle(ctx context.Context, args []string) error {
+	fs, o := newFlagSet("support-bundle")
+	var output, selectors string
+	var lines int64
+	fs.StringVar(&output, "output", "", "Output file, defaults to kubearmor-support-<timestamp>.tar.gz, - writes to stdout")
+	fs.StringVar(&selectors, "selector", strings.Join(supportbundle.DefaultPodSelectors, ";"),
+		"Semicolon separated label selectors of the pods logs are collected from")
+	fs.Int64Var(&lines, "log-lines", supportbundle.DefaultLogLines, "Number of log lines collected per container")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	clientset, c, err := o.clients()
+	if err != nil {
+		return err
+	}
+	collector := &supportbundle.Collector{
+		Clientset:    clientset,
+		Client:       c,
+		Namespace:    o.namespace,
+		PodSelectors: strings.Split(selectors, ";"),
+		LogLines:     lines,
+	}
+	// the release history is still worth collecting without it, a chart that
+	// can't be pulled is often the reason a bundle is collected
+	if collector.Helm, err = o.helmController(); err != nil {
+		fmt.Fprintf(os.Stderr, "helm release history is not collected: %s\n", err.Error())
+	}
+
+	if output == "" {
+		output = fmt.Sprintf("kubearmor-support-%s.tar.gz", time.Now().Format("20060102-150405"))
+	}
+	var w io.Writer = os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := collector.Write(ctx, w); err != nil {
+		return err
+	}
+	if output != "-" {
+		fmt.Fprintf(os.Stderr, "support bundle written to %s\n", output)
+	}
+	return nil
+}
@@ -35,14 +35,18 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/config"
 	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/controller"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/helm"
 	//+kubebuilder:scaffold:imports
 )
 
 var (
-	scheme         = runtime.NewScheme()
-	setupLog       = ctrl.Log.WithName("setup")
-	operatorConfig = controller.OperatorConfig{}
+	scheme   = runtime.NewScheme()
+	setupLog = ctrl.Log.WithName("setup")
+	// flagConfig holds operator configuration flags, set flags take precedence
+	// over the configuration file
+	flagConfig = config.Default()
 )
 
 func init() {
@@ -58,6 +62,7 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var configFile string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -68,13 +73,16 @@ func main() {
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
 	// operator configuration flags
-	flag.StringVar(&operatorConfig.Version, "version", "", "The helm chart version of the KubeArmor to deploy")
-	flag.StringVar(&operatorConfig.Repository, "repository", "https://kubearmor.github.io/charts",
+	flag.StringVar(&configFile, "config", "", "Path to the operator configuration file, flags that are set take precedence over it")
+	flag.StringVar(&flagConfig.Chart.Version, "version", "", "The helm chart version of the KubeArmor to deploy")
+	flag.StringVar(&flagConfig.Chart.Repository, "repository", flagConfig.Chart.Repository,
 		"The helm chart repository to be used to pull the KubeArmor chart")
-	flag.StringVar(&operatorConfig.Directory, "directory", "", "Path to chart directory if local chart is to be used")
-	flag.StringVar(&operatorConfig.ChartName, "chart", "kubearmor", "Helm chart release name")
-	flag.StringVar(&operatorConfig.SnitchPathPrefix, "pathprefix", "/rootfs/", "path prefix for runtime search")
-	flag.StringVar(&operatorConfig.OperatorDeploymentName, "deploymentName", "kubearmor-operator", "operator deployment name")
+	flag.StringVar(&flagConfig.Chart.Directory, "directory", "", "Path to chart directory if local chart is to be used")
+	flag.StringVar(&flagConfig.Chart.CacheDir, "chart-cache-dir", helm.DefaultChartCacheDir,
+		"Directory to cache pulled helm charts, mount a persistent volume to reuse charts across restarts")
+	flag.StringVar(&flagConfig.Chart.Name, "chart", flagConfig.Chart.Name, "Helm chart release name")
+	flag.StringVar(&flagConfig.Snitch.PathPrefix, "pathprefix", flagConfig.Snitch.PathPrefix, "path prefix for runtime search")
+	flag.StringVar(&flagConfig.Operator.DeploymentName, "deploymentName", flagConfig.Operator.DeploymentName, "operator deployment name")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -136,11 +144,37 @@ func main() {
 		setupLog.Error(err, "unable to create k8s clientSet")
 	}
 
-	// get operator deployment UID
-	if ns := os.Getenv("KUBEARMOR_OPERATOR_NS"); ns != "" {
-		operatorConfig.Namespace = ns
-	} else {
-		operatorConfig.Namespace = "kubearmor"
+	cfg := config.Default()
+	if configFile != "" {
+		cfg, err = config.Load(configFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load operator configuration", "file", configFile)
+			os.Exit(1)
+		}
+	}
+	applyFlags(cfg)
+	cfg.ApplyEnv()
+
+	operatorConfig := controller.OperatorConfig{
+		Version:                cfg.Chart.Version,
+		Repository:             cfg.Chart.Repository,
+		Directory:              cfg.Chart.Directory,
+		ChartCacheDir:          cfg.Chart.CacheDir,
+		DisableChartCache:      !cfg.Enabled(config.ChartCache),
+		AdoptLegacyResources:   cfg.Enabled(config.AdoptLegacyResources),
+		ChartName:              cfg.Chart.Name,
+		Namespace:              cfg.Namespace,
+		SnitchPathPrefix:       cfg.Snitch.PathPrefix,
+		SnitchImage:            cfg.Snitch.Image,
+		SnitchImagePullPolicy:  cfg.Snitch.ImagePullPolicy,
+		HelmTimeout:            cfg.Timeouts.HelmOperation.Duration,
+		HelmWait:               cfg.Helm.Wait,
+		HelmStorageDriver:      cfg.Helm.StorageDriver,
+		HelmMaxHistory:         cfg.Helm.MaxHistory,
+		OperatorDeploymentName: cfg.Operator.DeploymentName,
+		OperatorDeploymentUID:  cfg.Operator.DeploymentUID,
+		PodName:                os.Getenv(config.PodNameEnv),
+		PodNamespace:           os.Getenv(config.PodNamespaceEnv),
 	}
 
 	operator, err := controller.NewOperator(operatorConfig, mgr.GetClient(), k8sClientSet, mgr)
@@ -177,3 +211,25 @@ func main() {
 	// 	os.Exit(1)
 	// }
 }
+
+// applyFlags overrides the configuration with the operator flags set on the command line
+func applyFlags(cfg *config.OperatorConfiguration) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "version":
+			cfg.Chart.Version = flagConfig.Chart.Version
+		case "repository":
+			cfg.Chart.Repository = flagConfig.Chart.Repository
+		case "directory":
+			cfg.Chart.Directory = flagConfig.Chart.Directory
+		case "chart-cache-dir":
+			cfg.Chart.CacheDir = flagConfig.Chart.CacheDir
+		case "chart":
+			cfg.Chart.Name = flagConfig.Chart.Name
+		case "pathprefix":
+			cfg.Snitch.PathPrefix = flagConfig.Snitch.PathPrefix
+		case "deploymentName":
+			cfg.Operator.DeploymentName = flagConfig.Operator.DeploymentName
+		}
+	})
+}
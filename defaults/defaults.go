@@ -19,12 +19,49 @@ const (
 	ApparmorFsLabel string = "kubearmor.io/apparmorfs"
 	SecurityFsLabel string = "kubearmor.io/securityfs"
 	SeccompLabel    string = "kubearmor.io/seccomp"
+	// ProfileLabel is set by the operator to the node profile of the node
+	ProfileLabel string = "kubearmor.io/profile"
 
 	DeleteAction string = "DELETE"
 	AddAction    string = "ADD"
 
-	SnitchName              string = "kubearmor-snitch"
-	KubeArmorSnitchRoleName string = "kubearmor-snitch"
+	SnitchName string = "kubearmor-snitch"
+	// KubeArmorDaemonSetSelector selects the KubeArmor daemonsets and their pods
+	KubeArmorDaemonSetSelector string = "kubearmor-app=kubearmor"
+	KubeArmorSnitchRoleName    string = "kubearmor-snitch"
+
+	// kubearmorconfig annotations
+	SupportBundleAnnotation string = "operator.kubearmor.com/support-bundle"
+	// PausedAnnotation set to "true" on a kubearmorconfig pauses changes to the release
+	PausedAnnotation string = "operator.kubearmor.com/paused"
+	// SupportBundleSecretName is the secret on demand support bundles are stored in
+	SupportBundleSecretName string = "kubearmor-support-bundle"
+
+	// namespace annotations read by KubeArmor
+	FilePostureAnnotation         string = "kubearmor-file-posture"
+	CapabilitiesPostureAnnotation string = "kubearmor-capabilities-posture"
+	NetworkPostureAnnotation      string = "kubearmor-network-posture"
+	VisibilityAnnotation          string = "kubearmor-visibility"
+	// ManagedPosturesAnnotation lists the namespace annotations set by the operator
+	ManagedPosturesAnnotation string = "operator.kubearmor.com/managed-postures"
+
+	// event reasons
+	SnitchScheduledReason    string = "SnitchScheduled"
+	SnitchFailedReason       string = "SnitchFailed"
+	NodeConfigDetectedReason string = "NodeConfigDetected"
+	NodeConfigChangedReason  string = "NodeConfigChanged"
+	ReleaseInstalledReason   string = "ReleaseInstalled"
+	ReleaseUpgradedReason    string = "ReleaseUpgraded"
+	ReleaseFailedReason      string = "ReleaseFailed"
+	ReleaseRolledBackReason  string = "ReleaseRolledBack"
+	ReleaseDeferredReason    string = "ReleaseDeferred"
+	PreinstallCleanupReason  string = "PreinstallCleanup"
+	MigrationAppliedReason   string = "MigrationApplied"
+	SupportBundleReason      string = "SupportBundleCollected"
+	RolloutStepReason        string = "RolloutStep"
+	RolloutCompletedReason   string = "RolloutCompleted"
+	RolloutFailedReason      string = "RolloutFailed"
+	ConfigIgnoredReason      string = "ConfigIgnored"
 )
 
 var (
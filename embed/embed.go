@@ -1,8 +1,123 @@
 package embed
 
 import (
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	semver "github.com/Masterminds/semver/v3"
+	"sigs.k8s.io/yaml"
 )
 
-//go:embed *.tgz
+//go:embed *.tgz index.yaml
 var EmbedFs embed.FS
+
+// ChartEntry describes an embedded chart archive
+type ChartEntry struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	File    string `json:"file"`
+	Digest  string `json:"digest"`
+}
+
+type index struct {
+	Charts []ChartEntry `json:"charts"`
+}
+
+// Index returns all the embedded chart entries
+func Index() ([]ChartEntry, error) {
+	return readIndex(EmbedFs)
+}
+
+// Versions returns the embedded versions of the given chart, latest first
+func Versions(name string) ([]string, error) {
+	return versions(EmbedFs, name)
+}
+
+// Chart returns the embedded chart archive with the given name and version
+// after verifying its digest. An empty version selects the latest version
+func Chart(name, version string) ([]byte, ChartEntry, error) {
+	return chart(EmbedFs, name, version)
+}
+
+// SameVersion reports whether two chart versions are equal, comparing them as
+// semantic versions so that 1.3.8 and v1.3.8 are the same
+func SameVersion(a, b string) bool {
+	va, errA := semver.NewVersion(a)
+	vb, errB := semver.NewVersion(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return va.Equal(vb)
+}
+
+func readIndex(fsys fs.FS) ([]ChartEntry, error) {
+	data, err := fs.ReadFile(fsys, "index.yaml")
+	if err != nil {
+		return nil, err
+	}
+	idx := index{}
+	if err := yaml.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("error parsing embedded chart index: %s", err.Error())
+	}
+	return idx.Charts, nil
+}
+
+func versions(fsys fs.FS, name string) ([]string, error) {
+	entries, err := readIndex(fsys)
+	if err != nil {
+		return nil, err
+	}
+	var versions []*semver.Version
+	for _, entry := range entries {
+		if entry.Name != name {
+			continue
+		}
+		ver, err := semver.NewVersion(entry.Version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %s of embedded chart %s: %s", entry.Version, name, err.Error())
+		}
+		versions = append(versions, ver)
+	}
+	sort.Sort(sort.Reverse(semver.Collection(versions)))
+	result := make([]string, 0, len(versions))
+	for _, ver := range versions {
+		result = append(result, ver.Original())
+	}
+	return result, nil
+}
+
+func chart(fsys fs.FS, name, version string) ([]byte, ChartEntry, error) {
+	if version == "" {
+		versions, err := versions(fsys, name)
+		if err != nil {
+			return nil, ChartEntry{}, err
+		}
+		if len(versions) == 0 {
+			return nil, ChartEntry{}, fmt.Errorf("no embedded chart found for %s", name)
+		}
+		version = versions[0]
+	}
+	entries, err := readIndex(fsys)
+	if err != nil {
+		return nil, ChartEntry{}, err
+	}
+	for _, entry := range entries {
+		if entry.Name != name || !SameVersion(entry.Version, version) {
+			continue
+		}
+		archive, err := fs.ReadFile(fsys, entry.File)
+		if err != nil {
+			return nil, entry, err
+		}
+		sum := sha256.Sum256(archive)
+		if digest := "sha256:" + hex.EncodeToString(sum[:]); digest != entry.Digest {
+			return nil, entry, fmt.Errorf("digest mismatch for embedded chart %s: expected %s got %s", entry.File, entry.Digest, digest)
+		}
+		return archive, entry, nil
+	}
+	return nil, ChartEntry{}, fmt.Errorf("embedded chart %s version %s not found", name, version)
+}
@@ -0,0 +1,81 @@
+package embed
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndex(t *testing.T) {
+	entries, err := Index()
+	assert.NoError(t, err)
+
+	// every embedded archive must be indexed with a valid digest
+	archives, err := fs.Glob(EmbedFs, "*.tgz")
+	assert.NoError(t, err)
+	assert.Equal(t, len(archives), len(entries))
+	for _, entry := range entries {
+		_, _, err := Chart(entry.Name, entry.Version)
+		assert.NoError(t, err, entry.File)
+	}
+}
+
+func TestChart(t *testing.T) {
+	versions, err := Versions("kubearmor")
+	assert.NoError(t, err)
+	assert.Contains(t, versions, "v1.3.8")
+
+	_, entry, err := Chart("kubearmor", "")
+	assert.NoError(t, err)
+	assert.Equal(t, versions[0], entry.Version)
+
+	_, _, err = Chart("kubearmor", "v0.0.1")
+	assert.Error(t, err)
+}
+
+func TestChartVersions(t *testing.T) {
+	fsys := fstest.MapFS{}
+	index := "charts:\n"
+	for _, version := range []string{"v1.3.8", "v1.4.0", "v1.3.10"} {
+		archive := []byte("kubearmor " + version)
+		sum := sha256.Sum256(archive)
+		file := "kubearmor-" + version + ".tgz"
+		fsys[file] = &fstest.MapFile{Data: archive}
+		index += fmt.Sprintf("- name: kubearmor\n  version: %s\n  file: %s\n  digest: sha256:%s\n", version, file, hex.EncodeToString(sum[:]))
+	}
+	fsys["index.yaml"] = &fstest.MapFile{Data: []byte(index)}
+
+	versions, err := versions(fsys, "kubearmor")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v1.4.0", "v1.3.10", "v1.3.8"}, versions)
+
+	archive, entry, err := chart(fsys, "kubearmor", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.4.0", entry.Version)
+	assert.Equal(t, "kubearmor v1.4.0", string(archive))
+
+	// versions are compared as semantic versions
+	archive, entry, err = chart(fsys, "kubearmor", "1.3.8")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.3.8", entry.Version)
+	assert.Equal(t, "kubearmor v1.3.8", string(archive))
+
+	_, _, err = chart(fsys, "kubearmor", "v1.3.9")
+	assert.Error(t, err)
+
+	fsys["kubearmor-v1.3.10.tgz"] = &fstest.MapFile{Data: []byte("tampered")}
+	_, _, err = chart(fsys, "kubearmor", "v1.3.10")
+	assert.ErrorContains(t, err, "digest mismatch")
+}
+
+func TestSameVersion(t *testing.T) {
+	assert.True(t, SameVersion("v1.3.8", "1.3.8"))
+	assert.True(t, SameVersion("v1.3.8", "v1.3.8"))
+	assert.False(t, SameVersion("v1.3.8", "v1.3.9"))
+	assert.False(t, SameVersion("latest", "v1.3.8"))
+}
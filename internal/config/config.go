@@ -0,0 +1,231 @@
+// Package config loads the versioned operator configuration file, usually
+// mounted from a ConfigMap, and resolves it against environment variables
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersion of the operator configuration file
+	APIVersion = "operator.kubearmor.com/v1alpha1"
+	// Kind of the operator configuration file
+	Kind = "OperatorConfiguration"
+)
+
+// environment variables read by the operator, POD_NAME and POD_NAMESPACE are
+// expected to be set through the downward API
+const (
+	NamespaceEnv       = "KUBEARMOR_OPERATOR_NS"
+	PodNameEnv         = "POD_NAME"
+	PodNamespaceEnv    = "POD_NAMESPACE"
+	HelmDriverEnv      = "HELM_DRIVER"
+	defaultNamespace   = "kubearmor"
+	defaultHelmTimeout = 5 * time.Minute
+	defaultHelmDriver  = "secret"
+	defaultMaxHistory  = 10
+)
+
+// feature gates
+const (
+	// ChartCache caches pulled charts to survive repository outages
+	ChartCache = "ChartCache"
+	// AdoptLegacyResources lets the release take over the resources of
+	// installations older than v1.3.8 in place instead of deleting them
+	AdoptLegacyResources = "AdoptLegacyResources"
+)
+
+// knownFeatureGates maps the supported feature gates to their default
+var knownFeatureGates = map[string]bool{
+	ChartCache:           true,
+	AdoptLegacyResources: true,
+}
+
+// OperatorConfiguration is the operator configuration file
+type OperatorConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Chart configures the source of the KubeArmor chart
+	Chart ChartConfig `json:"chart,omitempty"`
+	// Namespace to deploy KubeArmor to, defaults to the operator namespace
+	Namespace string `json:"namespace,omitempty"`
+	// Operator identifies the operator deployment, discovered when empty
+	Operator OperatorIdentity `json:"operator,omitempty"`
+	// Snitch configures the node configuration detection jobs
+	Snitch SnitchConfig `json:"snitch,omitempty"`
+	// Timeouts of operator actions
+	Timeouts Timeouts `json:"timeouts,omitempty"`
+	// Helm configures helm operations
+	Helm HelmConfig `json:"helm,omitempty"`
+	// FeatureGates enables or disables optional operator features
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+}
+
+// ChartConfig configures the source of the KubeArmor chart
+type ChartConfig struct {
+	// Name of the chart or chartRef
+	Name string `json:"name,omitempty"`
+	// Repository to pull the chart from, "embed" uses the charts built into the operator
+	Repository string `json:"repository,omitempty"`
+	// Version of the chart to deploy
+	Version string `json:"version,omitempty"`
+	// Directory of a local chart, takes precedence over the repository
+	Directory string `json:"directory,omitempty"`
+	// CacheDir to cache pulled charts in
+	CacheDir string `json:"cacheDir,omitempty"`
+}
+
+// OperatorIdentity identifies the operator deployment owning the resources
+// created by the operator
+type OperatorIdentity struct {
+	DeploymentName string `json:"deploymentName,omitempty"`
+	DeploymentUID  string `json:"deploymentUID,omitempty"`
+}
+
+// SnitchConfig configures the snitch jobs
+type SnitchConfig struct {
+	// Image of the snitch
+	Image string `json:"image,omitempty"`
+	// ImagePullPolicy of the snitch image
+	ImagePullPolicy string `json:"imagePullPolicy,omitempty"`
+	// PathPrefix the host filesystem is mounted at
+	PathPrefix string `json:"pathPrefix,omitempty"`
+}
+
+// Timeouts of operator actions
+type Timeouts struct {
+	// HelmOperation is the timeout of helm install and upgrade operations
+	HelmOperation metav1.Duration `json:"helmOperation,omitempty"`
+}
+
+// HelmConfig configures helm operations
+type HelmConfig struct {
+	// Wait for the release resources to become ready, up to the helm operation
+	// timeout, before an install or upgrade succeeds
+	Wait bool `json:"wait"`
+	// StorageDriver stores the release in secrets, configmaps or a sql database
+	// reached through HELM_DRIVER_SQL_CONNECTION_STRING. Defaults to HELM_DRIVER
+	// or secret
+	StorageDriver string `json:"storageDriver,omitempty"`
+	// MaxHistory is the number of release revisions kept, 0 keeps all of them
+	MaxHistory int `json:"maxHistory"`
+}
+
+// Default returns the default operator configuration
+func Default() *OperatorConfiguration {
+	return &OperatorConfiguration{
+		TypeMeta: metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind},
+		Chart: ChartConfig{
+			Name:       "kubearmor",
+			Repository: "https://kubearmor.github.io/charts",
+		},
+		Operator: OperatorIdentity{
+			DeploymentName: "kubearmor-operator",
+		},
+		Snitch: SnitchConfig{
+			Image:           "kubearmor/kubearmor-snitch:latest",
+			ImagePullPolicy: "IfNotPresent",
+			PathPrefix:      "/rootfs/",
+		},
+		Timeouts: Timeouts{
+			HelmOperation: metav1.Duration{Duration: defaultHelmTimeout},
+		},
+		Helm: HelmConfig{
+			Wait:       true,
+			MaxHistory: defaultMaxHistory,
+		},
+		FeatureGates: map[string]bool{},
+	}
+}
+
+// Load reads the configuration file at the given path over the defaults
+func Load(path string) (*OperatorConfiguration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading operator configuration: %s", err.Error())
+	}
+	return Parse(data)
+}
+
+// Parse decodes an operator configuration over the defaults and validates it
+func Parse(data []byte) (*OperatorConfiguration, error) {
+	cfg := Default()
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("error decoding operator configuration: %s", err.Error())
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration version and values
+func (cfg *OperatorConfiguration) Validate() error {
+	if cfg.APIVersion != APIVersion || cfg.Kind != Kind {
+		return fmt.Errorf("unsupported operator configuration %s %s, expected %s %s", cfg.APIVersion, cfg.Kind, APIVersion, Kind)
+	}
+	var unknown []string
+	for gate := range cfg.FeatureGates {
+		if _, ok := knownFeatureGates[gate]; !ok {
+			unknown = append(unknown, gate)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown feature gates: %s", strings.Join(unknown, ", "))
+	}
+	switch cfg.Snitch.ImagePullPolicy {
+	case "Always", "IfNotPresent", "Never":
+	default:
+		return fmt.Errorf("invalid snitch imagePullPolicy %q", cfg.Snitch.ImagePullPolicy)
+	}
+	if cfg.Timeouts.HelmOperation.Duration <= 0 {
+		return fmt.Errorf("helm operation timeout must be positive")
+	}
+	switch cfg.Helm.StorageDriver {
+	case "", "secret", "configmap", "sql":
+	default:
+		return fmt.Errorf("invalid helm storageDriver %q, expected secret, configmap or sql", cfg.Helm.StorageDriver)
+	}
+	if cfg.Helm.MaxHistory < 0 {
+		return fmt.Errorf("helm maxHistory must not be negative")
+	}
+	return nil
+}
+
+// ApplyEnv resolves the namespace and helm storage driver from the environment
+// when not configured, KUBEARMOR_OPERATOR_NS takes precedence over the pod
+// namespace
+func (cfg *OperatorConfiguration) ApplyEnv() {
+	if cfg.Helm.StorageDriver == "" {
+		cfg.Helm.StorageDriver = os.Getenv(HelmDriverEnv)
+		if cfg.Helm.StorageDriver == "" {
+			cfg.Helm.StorageDriver = defaultHelmDriver
+		}
+	}
+	if cfg.Namespace != "" {
+		return
+	}
+	for _, env := range []string{NamespaceEnv, PodNamespaceEnv} {
+		if ns := os.Getenv(env); ns != "" {
+			cfg.Namespace = ns
+			return
+		}
+	}
+	cfg.Namespace = defaultNamespace
+}
+
+// Enabled reports whether the feature gate is enabled
+func (cfg *OperatorConfiguration) Enabled(gate string) bool {
+	if enabled, ok := cfg.FeatureGates[gate]; ok {
+		return enabled
+	}
+	return knownFeatureGates[gate]
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+apiVersion: operator.kubearmor.com/v1alpha1
+kind: OperatorConfiguration
+chart:
+  version: v1.3.8
+snitch:
+  image: registry.example.com/kubearmor-snitch:v1
+timeouts:
+  helmOperation: 10m
+helm:
+  wait: false
+  storageDriver: configmap
+  maxHistory: 3
+featureGates:
+  ChartCache: false
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.3.8", cfg.Chart.Version)
+	// unset values keep their defaults
+	assert.Equal(t, "kubearmor", cfg.Chart.Name)
+	assert.Equal(t, "IfNotPresent", cfg.Snitch.ImagePullPolicy)
+	assert.Equal(t, "registry.example.com/kubearmor-snitch:v1", cfg.Snitch.Image)
+	assert.Equal(t, 10*time.Minute, cfg.Timeouts.HelmOperation.Duration)
+	assert.False(t, cfg.Helm.Wait)
+	assert.True(t, Default().Helm.Wait)
+	assert.Equal(t, "configmap", cfg.Helm.StorageDriver)
+	assert.Equal(t, 3, cfg.Helm.MaxHistory)
+	assert.Equal(t, 10, Default().Helm.MaxHistory)
+	assert.False(t, cfg.Enabled(ChartCache))
+	assert.True(t, Default().Enabled(ChartCache))
+	assert.True(t, cfg.Enabled(AdoptLegacyResources))
+
+	for name, data := range map[string]string{
+		"unsupported version": "apiVersion: operator.kubearmor.com/v2\nkind: OperatorConfiguration\n",
+		"unknown field":       "apiVersion: operator.kubearmor.com/v1alpha1\nkind: OperatorConfiguration\nchartName: kubearmor\n",
+		"unknown gate":        "apiVersion: operator.kubearmor.com/v1alpha1\nkind: OperatorConfiguration\nfeatureGates:\n  Foo: true\n",
+		"invalid pull policy": "apiVersion: operator.kubearmor.com/v1alpha1\nkind: OperatorConfiguration\nsnitch:\n  imagePullPolicy: Sometimes\n",
+		"invalid driver":      "apiVersion: operator.kubearmor.com/v1alpha1\nkind: OperatorConfiguration\nhelm:\n  storageDriver: memory\n",
+		"negative history":    "apiVersion: operator.kubearmor.com/v1alpha1\nkind: OperatorConfiguration\nhelm:\n  maxHistory: -1\n",
+	} {
+		_, err := Parse([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv(NamespaceEnv, "")
+	t.Setenv(PodNamespaceEnv, "")
+	t.Setenv(HelmDriverEnv, "")
+	cfg := Default()
+	cfg.ApplyEnv()
+	assert.Equal(t, "kubearmor", cfg.Namespace)
+	assert.Equal(t, "secret", cfg.Helm.StorageDriver)
+
+	// HELM_DRIVER is used when no storage driver is configured
+	t.Setenv(HelmDriverEnv, "configmap")
+	cfg = Default()
+	cfg.ApplyEnv()
+	assert.Equal(t, "configmap", cfg.Helm.StorageDriver)
+	cfg = Default()
+	cfg.Helm.StorageDriver = "sql"
+	cfg.ApplyEnv()
+	assert.Equal(t, "sql", cfg.Helm.StorageDriver)
+
+	t.Setenv(PodNamespaceEnv, "operator-ns")
+	cfg = Default()
+	cfg.ApplyEnv()
+	assert.Equal(t, "operator-ns", cfg.Namespace)
+
+	t.Setenv(NamespaceEnv, "kubearmor-ns")
+	cfg = Default()
+	cfg.ApplyEnv()
+	assert.Equal(t, "kubearmor-ns", cfg.Namespace)
+
+	// configured namespace is kept
+	cfg.Namespace = "configured"
+	cfg.ApplyEnv()
+	assert.Equal(t, "configured", cfg.Namespace)
+}
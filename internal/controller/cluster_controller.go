@@ -6,15 +6,18 @@ package controller
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"reflect"
 	"slices"
 	"strings"
 	"sync"
 
+	"github.com/go-logr/logr"
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
 	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
 	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/helm"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/metrics"
 
-	"go.uber.org/zap"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
@@ -25,22 +28,31 @@ import (
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/yaml"
 )
 
 var (
-	informer                 informers.SharedInformerFactory
-	operatorDeploymentUID    string
-	operatorDeploymentName   string
-	snitchPathPrefix         string
-	operatorWatchedNamespace string
+	informer                    informers.SharedInformerFactory
+	operatorDeploymentUID       string
+	operatorDeploymentName      string
+	operatorDeploymentNamespace string
+	snitchPathPrefix            = "/rootfs/"
+	operatorWatchedNamespace    string
+	snitchImage                                   = "kubearmor/kubearmor-snitch:latest"
+	snitchImagePullPolicy       corev1.PullPolicy = corev1.PullIfNotPresent
 )
 
 // WatcherConfig provides configurations for ClusterWatcher instance
 type WatcherConfig struct {
-	SnitchPathPrefix         string
-	OperatorWatchedNamespace string
-	OperatorDeploymentName   string
-	OperatorDeploymentUID    string
+	SnitchPathPrefix            string
+	SnitchImage                 string
+	SnitchImagePullPolicy       string
+	OperatorWatchedNamespace    string
+	OperatorDeploymentName      string
+	OperatorDeploymentNamespace string
+	OperatorDeploymentUID       string
 }
 
 // ClusterWatcher providers a node watcher that watches for nodes across the cluster
@@ -49,10 +61,16 @@ type ClusterWatcher struct {
 	helmController *helm.Controller
 	nodes          map[string]node
 	nodesLock      *sync.Mutex
-	log            *zap.SugaredLogger
+	log            logr.Logger
 	client         *kubernetes.Clientset
 	daemonsets     map[string]int
 	daemonsetsLock *sync.Mutex
+	// node configuration changes not yet applied to the release, guarded by daemonsetsLock
+	pendingNodeChanges int
+	// pending changes the running upgrade was started with, guarded by daemonsetsLock
+	upgradingNodeChanges int
+	recorder             record.EventRecorder
+	jobInformer          cache.SharedIndexInformer
 }
 
 // node represent the type for node configuration
@@ -70,24 +88,29 @@ type node struct {
 var nodeConfigs []node
 
 // NewClusterWatcher construct a new clusterwatcher from the provided k8s clientset
-func NewClusterWatcher(cfg WatcherConfig, client *kubernetes.Clientset, helmController *helm.Controller) (*ClusterWatcher, error) {
-	logger, _ := zap.NewProduction()
-	log := logger.With(zap.String("component", "clusterwatcher")).Sugar()
+func NewClusterWatcher(cfg WatcherConfig, client *kubernetes.Clientset, helmController *helm.Controller, recorder record.EventRecorder) (*ClusterWatcher, error) {
+	log := ctrl.Log.WithName("clusterwatcher")
 	if informer == nil {
 		informer = informers.NewSharedInformerFactory(client, 0)
 	}
 
 	operatorDeploymentName = cfg.OperatorDeploymentName
 	operatorDeploymentUID = cfg.OperatorDeploymentUID
+	operatorDeploymentNamespace = cfg.OperatorDeploymentNamespace
 	operatorWatchedNamespace = cfg.OperatorWatchedNamespace
 	snitchPathPrefix = cfg.SnitchPathPrefix
+	if cfg.SnitchImage != "" {
+		snitchImage = cfg.SnitchImage
+	}
+	if cfg.SnitchImagePullPolicy != "" {
+		snitchImagePullPolicy = corev1.PullPolicy(cfg.SnitchImagePullPolicy)
+	}
 
 	if operatorWatchedNamespace == "" {
-		log.Fatal("operator is not watching any namespace")
 		return nil, fmt.Errorf("operator watched namespace is empty")
 	}
 
-	log.Infof("clusterwatcher has configured %+v", cfg)
+	log.Info("clusterwatcher has been configured", "config", cfg)
 
 	return &ClusterWatcher{
 		helmController: helmController,
@@ -97,6 +120,13 @@ func NewClusterWatcher(cfg WatcherConfig, client *kubernetes.Clientset, helmCont
 		nodesLock:      &sync.Mutex{},
 		daemonsetsLock: &sync.Mutex{},
 		client:         client,
+		recorder:       recorder,
+		jobInformer: informers.NewSharedInformerFactoryWithOptions(client, 0,
+			informers.WithNamespace(operatorWatchedNamespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.LabelSelector = "kubearmor-app=" + defaults.KubeArmorSnitchRoleName
+			}),
+		).Batch().V1().Jobs().Informer(),
 	}, nil
 
 }
@@ -109,33 +139,16 @@ func (clusterWatcher *ClusterWatcher) WatchNodes() {
 	nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			if nodeObj, ok := obj.(*corev1.Node); ok {
-				runtime := nodeObj.Status.NodeInfo.ContainerRuntimeVersion
-				runtime = strings.Split(runtime, ":")[0]
 				if val, ok := nodeObj.Labels[defaults.OsLabel]; ok && val == "linux" {
-					log.Infof("Installing snitch on node %s", nodeObj.Name)
-					// install snitch role, rolebinding and sa
-					_, err := clusterWatcher.client.RbacV1().ClusterRoles().Create(context.Background(), genSnitchClusterRole(), metav1.CreateOptions{})
-					if err != nil && !errors.IsAlreadyExists(err) {
-						log.Warnf("cannot create snitch clusterrole error=%s", err.Error())
-						return
-					}
-					_, err = clusterWatcher.client.RbacV1().ClusterRoleBindings().Create(context.Background(), genSnitchClusterRoleBinding(), metav1.CreateOptions{})
-					if err != nil && !errors.IsAlreadyExists(err) {
-						log.Warnf("cannot create snitch clusterrolebinding error=%s", err.Error())
-						return
-					}
-					_, err = clusterWatcher.client.CoreV1().ServiceAccounts(operatorWatchedNamespace).Create(context.Background(), genSnitchServiceAccount(), metav1.CreateOptions{})
-					if err != nil && !errors.IsAlreadyExists(err) {
-						log.Warnf("cannot create snitch serviceaccount error=%s", err.Error())
+					log.Info("installing snitch", "node", nodeObj.Name)
+					if err := RunSnitch(context.Background(), clusterWatcher.client, operatorWatchedNamespace, nodeObj); err != nil {
+						metrics.SnitchJobs.WithLabelValues(metrics.ResultFailure).Inc()
+						log.Error(err, "cannot run snitch", "node", nodeObj.Name)
+						clusterWatcher.recorder.Eventf(nodeObj, corev1.EventTypeWarning, defaults.SnitchFailedReason, "unable to schedule snitch: %s", err.Error())
 						return
 					}
-					// deploy snitch job
-					_, err = clusterWatcher.client.BatchV1().Jobs(operatorWatchedNamespace).Create(context.Background(), genSnitchDeployment(nodeObj.Name, runtime), metav1.CreateOptions{})
-					if err != nil {
-						log.Warnf("Cannot run snitch on node %s, error=%s", nodeObj.Name, err.Error())
-						return
-					}
-					log.Infof("Snitch was installed on node %s", nodeObj.Name)
+					log.Info("snitch was installed", "node", nodeObj.Name)
+					clusterWatcher.recorder.Event(nodeObj, corev1.EventTypeNormal, defaults.SnitchScheduledReason, "snitch scheduled to detect node configuration")
 				}
 			}
 		},
@@ -146,33 +159,13 @@ func (clusterWatcher *ClusterWatcher) WatchNodes() {
 					oldRand = old.Labels[defaults.RandLabel]
 				}
 				if val, ok := nodeObj.Labels[defaults.OsLabel]; ok && val == "linux" && oldRand != nodeObj.Labels[defaults.RandLabel] {
-					newNode := node{}
-					if val, ok := nodeObj.Labels[defaults.EnforcerLabel]; ok {
-						newNode.Enforcer = val
-					}
-					if val, ok := nodeObj.Labels[defaults.ArchLabel]; ok {
-						newNode.Arch = val
-					}
-					if val, ok := nodeObj.Labels[defaults.RuntimeLabel]; ok {
-						newNode.Runtime = val
-					}
-					if val, ok := nodeObj.Labels[defaults.SocketLabel]; ok {
-						newNode.RuntimeSocket = val
-					}
-					if val, ok := nodeObj.Labels[defaults.BTFLabel]; ok {
-						newNode.BTF = val
-					}
-					if val, ok := nodeObj.Labels[defaults.ApparmorFsLabel]; ok {
-						newNode.ApparmorFs = val
-					}
-					if val, ok := nodeObj.Labels[defaults.SeccompLabel]; ok {
-						newNode.Seccomp = val
-					}
+					newNode := nodeFromLabels(nodeObj.Labels)
 					clusterWatcher.nodesLock.Lock()
 					nodeModified := false
 					if _, ok := clusterWatcher.nodes[nodeObj.Name]; !ok {
 						clusterWatcher.nodes[nodeObj.Name] = newNode
-						clusterWatcher.log.Infof("Node %s has been added", nodeObj.Name)
+						clusterWatcher.log.Info("node has been added", "node", nodeObj.Name)
+						clusterWatcher.recorder.Eventf(nodeObj, corev1.EventTypeNormal, defaults.NodeConfigDetectedReason, "detected enforcer=%s runtime=%s btf=%s", newNode.Enforcer, newNode.Runtime, newNode.BTF)
 					} else {
 						if clusterWatcher.nodes[nodeObj.Name].Arch != newNode.Arch ||
 							clusterWatcher.nodes[nodeObj.Name].Enforcer != newNode.Enforcer ||
@@ -182,9 +175,11 @@ func (clusterWatcher *ClusterWatcher) WatchNodes() {
 							clusterWatcher.nodes[nodeObj.Name].Seccomp != newNode.Seccomp {
 							clusterWatcher.nodes[nodeObj.Name] = newNode
 							nodeModified = true
-							clusterWatcher.log.Infof("Node %s was updated", nodeObj.Name)
+							clusterWatcher.log.Info("node was updated", "node", nodeObj.Name)
+							clusterWatcher.recorder.Eventf(nodeObj, corev1.EventTypeNormal, defaults.NodeConfigChangedReason, "node configuration changed to enforcer=%s runtime=%s btf=%s", newNode.Enforcer, newNode.Runtime, newNode.BTF)
 						}
 					}
+					clusterWatcher.updateNodeMetrics()
 					clusterWatcher.nodesLock.Unlock()
 					if nodeModified {
 						clusterWatcher.updateDaemonsets(defaults.DeleteAction, newNode)
@@ -192,8 +187,7 @@ func (clusterWatcher *ClusterWatcher) WatchNodes() {
 					clusterWatcher.updateDaemonsets(defaults.AddAction, newNode)
 				}
 			} else {
-				log.Warnf("Cannot convert object to node struct")
-				log.Warn(newObj)
+				log.Info("cannot convert object to node", "object", newObj)
 			}
 		},
 		DeleteFunc: func(obj interface{}) {
@@ -201,6 +195,7 @@ func (clusterWatcher *ClusterWatcher) WatchNodes() {
 				clusterWatcher.nodesLock.Lock()
 				deletedNode := clusterWatcher.nodes[nodeObj.Name]
 				delete(clusterWatcher.nodes, nodeObj.Name)
+				clusterWatcher.updateNodeMetrics()
 				clusterWatcher.nodesLock.Unlock()
 				clusterWatcher.updateDaemonsets(defaults.DeleteAction, deletedNode)
 			}
@@ -210,6 +205,137 @@ func (clusterWatcher *ClusterWatcher) WatchNodes() {
 	nodeInformer.Run(wait.NeverStop)
 }
 
+// WatchSnitchJobs watches snitch jobs deployed by the operator and records their results
+func (clusterWatcher *ClusterWatcher) WatchSnitchJobs() {
+	jobInformer := clusterWatcher.jobInformer
+	jobInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldJob, ok := oldObj.(*batchv1.Job)
+			if !ok {
+				return
+			}
+			newJob, ok := newObj.(*batchv1.Job)
+			if !ok {
+				return
+			}
+			if _, finished := snitchJobResult(oldJob); finished {
+				return
+			}
+			if result, finished := snitchJobResult(newJob); finished {
+				metrics.SnitchJobs.WithLabelValues(result).Inc()
+				if result == metrics.ResultFailure {
+					nodeName := newJob.Spec.Template.Spec.NodeName
+					clusterWatcher.log.Info("snitch job failed", "job", newJob.Name, "node", nodeName)
+					// recorded on the node object so that the event is found by its uid
+					nodeObj, err := informer.Core().V1().Nodes().Lister().Get(nodeName)
+					if err != nil {
+						clusterWatcher.log.Error(err, "unable to record snitch failure", "node", nodeName)
+						return
+					}
+					clusterWatcher.recorder.Eventf(nodeObj, corev1.EventTypeWarning, defaults.SnitchFailedReason, "snitch job %s failed", newJob.Name)
+				}
+			}
+		},
+	})
+
+	jobInformer.Run(wait.NeverStop)
+}
+
+// SyncCheck is a readiness check reporting whether node and snitch job informers
+// have synced
+func (clusterWatcher *ClusterWatcher) SyncCheck(_ *http.Request) error {
+	if !informer.Core().V1().Nodes().Informer().HasSynced() {
+		return fmt.Errorf("node informer has not synced")
+	}
+	if !clusterWatcher.jobInformer.HasSynced() {
+		return fmt.Errorf("snitch job informer has not synced")
+	}
+	return nil
+}
+
+// snitchJobResult returns the result of a finished snitch job
+func snitchJobResult(job *batchv1.Job) (string, bool) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return metrics.ResultSuccess, true
+		case batchv1.JobFailed:
+			return metrics.ResultFailure, true
+		}
+	}
+	return "", false
+}
+
+// updateNodeMetrics updates the number of nodes per node configuration, must
+// be called with nodesLock held
+func (clusterWatcher *ClusterWatcher) updateNodeMetrics() {
+	metrics.Nodes.Reset()
+	for _, n := range clusterWatcher.nodes {
+		metrics.Nodes.WithLabelValues(n.Enforcer, n.Runtime, n.BTF).Inc()
+	}
+}
+
+// nodeFromLabels returns the node configuration detected by snitch from the node labels
+func nodeFromLabels(labels map[string]string) node {
+	return node{
+		Enforcer:      labels[defaults.EnforcerLabel],
+		Runtime:       labels[defaults.RuntimeLabel],
+		RuntimeSocket: labels[defaults.SocketLabel],
+		Arch:          labels[defaults.ArchLabel],
+		BTF:           labels[defaults.BTFLabel],
+		ApparmorFs:    labels[defaults.ApparmorFsLabel],
+		Seccomp:       labels[defaults.SeccompLabel],
+	}
+}
+
+// DetectedNodeConfigs returns the distinct node configurations of the linux nodes
+// that have been probed by snitch, in the form used for helm values
+func DetectedNodeConfigs(nodes []corev1.Node) []map[string]interface{} {
+	detected := []node{}
+	for _, nodeObj := range nodes {
+		if nodeObj.Labels[defaults.OsLabel] != "linux" || nodeObj.Labels[defaults.RandLabel] == "" {
+			continue
+		}
+		if n := nodeFromLabels(nodeObj.Labels); !slices.Contains(detected, n) {
+			detected = append(detected, n)
+		}
+	}
+	configs := []map[string]interface{}{}
+	for _, n := range detected {
+		configs = append(configs, convertNodeStructToMapOfStringInterface(n))
+	}
+	return configs
+}
+
+// ParseNodeConfigs parses a YAML list of node configurations, as printed by
+// the nodes command of the operator CLI
+func ParseNodeConfigs(data []byte) ([]map[string]interface{}, error) {
+	nodes := []node{}
+	if err := yaml.UnmarshalStrict(data, &nodes); err != nil {
+		return nil, fmt.Errorf("error parsing node configurations: %s", err.Error())
+	}
+	configs := []map[string]interface{}{}
+	for _, n := range nodes {
+		configs = append(configs, convertNodeStructToMapOfStringInterface(n))
+	}
+	return configs, nil
+}
+
+// NodeConfigHelmValues wraps node configurations into the helm values consumed
+// by helm.Controller.UpdateNodeConfigHelmValues
+func NodeConfigHelmValues(configs []map[string]interface{}) []map[string]interface{} {
+	values := []map[string]interface{}{}
+	for _, config := range configs {
+		values = append(values, map[string]interface{}{
+			"config": config,
+		})
+	}
+	return values
+}
+
 func generateNodeConfigHelmValues(nodes []node) []map[string]interface{} {
 	nodeConfigsValues := []map[string]interface{}{}
 
@@ -237,17 +363,26 @@ func convertNodeStructToMapOfStringInterface(node node) map[string]interface{} {
 
 func (clusterWatcher *ClusterWatcher) upgradeRelease() {
 	clusterWatcher.helmController.UpdateNodeConfigHelmValues(generateNodeConfigHelmValues(nodeConfigs))
-	release, err := clusterWatcher.helmController.UpgradeRelease(context.Background())
-	if err != nil {
-		clusterWatcher.log.Warnf("error updating release after node config update %s", err.Error())
-		return
+	clusterWatcher.helmController.RequestUpgrade("node configuration changed")
+}
+
+// trackUpgrade resets the pending node configuration changes once an upgrade
+// that started after them has succeeded
+func (clusterWatcher *ClusterWatcher) trackUpgrade(result helm.UpgradeResult) {
+	clusterWatcher.daemonsetsLock.Lock()
+	defer clusterWatcher.daemonsetsLock.Unlock()
+	switch result.Phase {
+	case operatorv1.UpgradeRunning:
+		clusterWatcher.upgradingNodeChanges = clusterWatcher.pendingNodeChanges
+	case operatorv1.UpgradeSucceeded:
+		clusterWatcher.pendingNodeChanges -= min(clusterWatcher.upgradingNodeChanges, clusterWatcher.pendingNodeChanges)
+		clusterWatcher.upgradingNodeChanges = 0
+		metrics.PendingNodeChanges.Set(float64(clusterWatcher.pendingNodeChanges))
 	}
-	clusterWatcher.log.Infof("successfully upgraded release %s revision %s", release.Name, release.Version)
-	clusterWatcher.log.Infof("chart info, status=%s chartVersion=%s", release.Info.Status, release.Chart.Metadata.Version)
 }
 
 func (clusterWatcher *ClusterWatcher) updateDaemonsets(action string, nodeInstance node) {
-	clusterWatcher.log.Info("updating daemonset")
+	clusterWatcher.log.V(1).Info("updating node configurations")
 	daemonsetName := strings.Join([]string{
 		"kubearmor",
 		strings.ReplaceAll(nodeInstance.Enforcer, ".", "-"),
@@ -259,7 +394,9 @@ func (clusterWatcher *ClusterWatcher) updateDaemonsets(action string, nodeInstan
 		clusterWatcher.daemonsets[daemonsetName]++
 		if !slices.Contains(nodeConfigs, nodeInstance) {
 			nodeConfigs = append(nodeConfigs, nodeInstance)
-			clusterWatcher.log.Infof("[ADD] nodeConfig: %+v", nodeConfigs)
+			clusterWatcher.log.V(1).Info("added node configuration", "nodeConfigs", nodeConfigs)
+			clusterWatcher.pendingNodeChanges++
+			metrics.PendingNodeChanges.Set(float64(clusterWatcher.pendingNodeChanges))
 			// update node config in helm values
 			clusterWatcher.upgradeRelease()
 		}
@@ -269,14 +406,16 @@ func (clusterWatcher *ClusterWatcher) updateDaemonsets(action string, nodeInstan
 				clusterWatcher.daemonsets[daemonsetName] = 0
 				nodeConfigs = slices.DeleteFunc(nodeConfigs, func(n node) bool { return reflect.DeepEqual(n, nodeInstance) })
 				// update node config in helm values
-				clusterWatcher.log.Infof("[DELETE] nodeConfig: %+v", nodeConfigs)
+				clusterWatcher.log.V(1).Info("deleted node configuration", "nodeConfigs", nodeConfigs)
+				clusterWatcher.pendingNodeChanges++
+				metrics.PendingNodeChanges.Set(float64(clusterWatcher.pendingNodeChanges))
 				clusterWatcher.upgradeRelease()
 			} else {
 				clusterWatcher.daemonsets[daemonsetName]--
 			}
 		}
 	}
-	clusterWatcher.log.Info("updated daemonset")
+	clusterWatcher.log.V(1).Info("updated node configurations")
 	clusterWatcher.daemonsetsLock.Unlock()
 }
 
@@ -284,11 +423,34 @@ func (clusterWatcher *ClusterWatcher) updateDaemonsets(action string, nodeInstan
 // snitch k8s resources
 // ====================
 
+// RunSnitch creates the snitch rbac resources if they don't exist and deploys a
+// snitch job in the namespace, which labels the node with its configuration
+func RunSnitch(ctx context.Context, client kubernetes.Interface, namespace string, nodeObj *corev1.Node) error {
+	_, err := client.RbacV1().ClusterRoles().Create(ctx, genSnitchClusterRole(), metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("cannot create snitch clusterrole: %s", err.Error())
+	}
+	_, err = client.RbacV1().ClusterRoleBindings().Create(ctx, genSnitchClusterRoleBinding(namespace), metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("cannot create snitch clusterrolebinding: %s", err.Error())
+	}
+	_, err = client.CoreV1().ServiceAccounts(namespace).Create(ctx, genSnitchServiceAccount(namespace), metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("cannot create snitch serviceaccount: %s", err.Error())
+	}
+	runtime := strings.Split(nodeObj.Status.NodeInfo.ContainerRuntimeVersion, ":")[0]
+	_, err = client.BatchV1().Jobs(namespace).Create(ctx, genSnitchDeployment(nodeObj.Name, runtime), metav1.CreateOptions{})
+	return err
+}
+
 func genSnitchDeployment(nodename string, runtime string) *batchv1.Job {
 	job := batchv1.Job{}
 	job = *addOwnership(&job).(*batchv1.Job)
 	ttls := int32(100)
 	job.GenerateName = "kubearmor-snitch-"
+	job.Labels = map[string]string{
+		"kubearmor-app": defaults.KubeArmorSnitchRoleName,
+	}
 	var rootUser int64 = 0
 	job.Spec = batchv1.JobSpec{
 		TTLSecondsAfterFinished: &ttls,
@@ -302,7 +464,7 @@ func genSnitchDeployment(nodename string, runtime string) *batchv1.Job {
 				Containers: []corev1.Container{
 					{
 						Name:  "snitch",
-						Image: "kubearmor/kubearmor-snitch:latest",
+						Image: snitchImage,
 						Args: []string{
 							"--nodename=$(NODE_NAME)",
 							"--pathprefix=" + snitchPathPrefix,
@@ -316,7 +478,7 @@ func genSnitchDeployment(nodename string, runtime string) *batchv1.Job {
 								}},
 							},
 						},
-						ImagePullPolicy: corev1.PullIfNotPresent,
+						ImagePullPolicy: snitchImagePullPolicy,
 						VolumeMounts: []corev1.VolumeMount{
 
 							{
@@ -445,7 +607,7 @@ func genSnitchClusterRole() *rbacv1.ClusterRole {
 	return addOwnership(cr).(*rbacv1.ClusterRole)
 }
 
-func genSnitchClusterRoleBinding() *rbacv1.ClusterRoleBinding {
+func genSnitchClusterRoleBinding(namespace string) *rbacv1.ClusterRoleBinding {
 	crb := &rbacv1.ClusterRoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: defaults.KubeArmorSnitchRoleName + "-binding",
@@ -454,7 +616,7 @@ func genSnitchClusterRoleBinding() *rbacv1.ClusterRoleBinding {
 			{
 				Kind:      "ServiceAccount",
 				Name:      defaults.KubeArmorSnitchRoleName,
-				Namespace: operatorWatchedNamespace,
+				Namespace: namespace,
 			},
 		},
 		RoleRef: rbacv1.RoleRef{
@@ -466,11 +628,11 @@ func genSnitchClusterRoleBinding() *rbacv1.ClusterRoleBinding {
 	return addOwnership(crb).(*rbacv1.ClusterRoleBinding)
 }
 
-func genSnitchServiceAccount() *corev1.ServiceAccount {
+func genSnitchServiceAccount(namespace string) *corev1.ServiceAccount {
 	sa := &corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      defaults.KubeArmorSnitchRoleName,
-			Namespace: operatorWatchedNamespace,
+			Namespace: namespace,
 		},
 	}
 	return addOwnership(sa).(*corev1.ServiceAccount)
@@ -488,12 +650,18 @@ func addOwnership(obj interface{}) interface{} {
 			UID:        types.UID(operatorDeploymentUID),
 		},
 	}
+	// namespaced objects can only be owned by a deployment in their namespace
+	sameNamespace := operatorDeploymentNamespace == "" || operatorDeploymentNamespace == operatorWatchedNamespace
 	switch resource := obj.(type) {
 	case *batchv1.Job:
-		resource.OwnerReferences = OwnerReferences
+		if sameNamespace {
+			resource.OwnerReferences = OwnerReferences
+		}
 		return resource
 	case *corev1.ServiceAccount:
-		resource.OwnerReferences = OwnerReferences
+		if sameNamespace {
+			resource.OwnerReferences = OwnerReferences
+		}
 		return resource
 	case *rbacv1.ClusterRole:
 		resource.OwnerReferences = OwnerReferences
@@ -5,6 +5,11 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
 )
 
 var (
@@ -26,3 +31,36 @@ func TestGenerateNodeConfigHelmValues(t *testing.T) {
 	assert.EqualValues(t, nodes[0], nodemap[0]["config"])
 	log.Printf("nodemap: %+v", nodemap)
 }
+
+func TestDetectedNodeConfigs(t *testing.T) {
+	labels := map[string]string{
+		defaults.OsLabel:         "linux",
+		defaults.RandLabel:       "abcd",
+		defaults.EnforcerLabel:   "bpf",
+		defaults.RuntimeLabel:    "cri-o",
+		defaults.SocketLabel:     "run_crio_crio.sock",
+		defaults.BTFLabel:        "yes",
+		defaults.ApparmorFsLabel: "yes",
+		defaults.SeccompLabel:    "no",
+	}
+	nodeList := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Labels: labels}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Labels: labels}},
+		// not yet probed by snitch
+		{ObjectMeta: metav1.ObjectMeta{Name: "c", Labels: map[string]string{defaults.OsLabel: "linux"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "d", Labels: map[string]string{defaults.OsLabel: "windows"}}},
+	}
+	configs := DetectedNodeConfigs(nodeList)
+	assert.Equal(t, []map[string]interface{}{convertNodeStructToMapOfStringInterface(nodes[0])}, configs)
+
+	// node configurations printed by the CLI parse back to the same values
+	data, err := yaml.Marshal(configs)
+	assert.NoError(t, err)
+	parsed, err := ParseNodeConfigs(data)
+	assert.NoError(t, err)
+	assert.Equal(t, NodeConfigHelmValues(configs), NodeConfigHelmValues(parsed))
+	assert.Equal(t, generateNodeConfigHelmValues(nodes), NodeConfigHelmValues(parsed))
+
+	_, err = ParseNodeConfigs([]byte("- enforcer: bpf\n  kernel: 6.1\n"))
+	assert.Error(t, err)
+}
@@ -17,33 +17,49 @@ limitations under the License.
 package controller
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"reflect"
-	"strings"
-	"time"
 
 	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
 	helm "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/helm"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
 )
 
 // KubeArmorConfigReconciler reconciles a KubeArmorConfig object
 type KubeArmorConfigReconciler struct {
 	helmController *helm.Controller
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// APIReader reads valuesFrom sources from the API server, the cache only
+	// holds the metadata of configmaps and secrets
+	APIReader client.Reader
 }
 
 //+kubebuilder:rbac:groups=operator.kubearmor.com,resources=kubearmorconfigs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=operator.kubearmor.com,resources=kubearmorconfigs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=operator.kubearmor.com,resources=kubearmorconfigs/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get
+//+kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -66,41 +82,244 @@ func (r *KubeArmorConfigReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 	if !config.GetDeletionTimestamp().IsZero() {
 		// kubearmorconfig CR instance has been deleted
 	}
+	active, err := activeKubeArmorConfig(ctx, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if active == nil || active.Namespace != config.Namespace || active.Name != config.Name {
+		return ctrl.Result{}, r.ignore(ctx, config, active)
+	}
 	// update helm values from KubeArmorConfig CR instance
 	// do helm upgrade
-	logger.Info("upgrading release with kubearmorconfig changes")
-	r.helmController.UpdateHelmValuesFromKubeArmorConfig(config)
-	release, err := r.helmController.UpgradeRelease(ctx)
+	logger.Info("requesting release upgrade with kubearmorconfig changes")
+	if err := r.helmController.UseChartVersion(config.Spec.Version); err != nil {
+		logger.Error(err, "unable to use requested chart version", "version", config.Spec.Version)
+		r.Recorder.Eventf(config, corev1.EventTypeWarning, defaults.ReleaseFailedReason, "unable to use chart version %s: %s", config.Spec.Version, err.Error())
+		return ctrl.Result{}, err
+	}
+	userValues, err := ResolveUserValues(ctx, r.APIReader, config)
 	if err != nil {
-		if strings.Contains(err.Error(), "nodes are not processed or kubearmorconfig") {
-			return ctrl.Result{RequeueAfter: 1 * time.Minute}, nil
-		}
+		logger.Error(err, "unable to resolve user supplied helm values")
+		r.Recorder.Eventf(config, corev1.EventTypeWarning, defaults.ReleaseFailedReason, "unable to resolve helm values: %s", err.Error())
 		return ctrl.Result{}, err
 	}
-	logger.Info("successfully upgraded release", "name", release.Name, "version", release.Version)
-	logger.Info("release status info", "status", release.Info.Status, "chartVersion", release.Chart.Metadata.Version)
-	if release != nil {
-		var manifests bytes.Buffer
-		fmt.Fprintf(&manifests, strings.TrimSpace(release.Manifest))
-		for _, m := range release.Hooks {
-			fmt.Fprintf(&manifests, "---\n# Source: %s\n%s\n", m.Path, m.Manifest)
+	message := ""
+	argsErr := r.helmController.ValidateKubeArmorArgs(config, userValues)
+	if argsErr != nil {
+		message = fmt.Sprintf("invalid kubearmor arguments: %s", argsErr.Error())
+	}
+	if config.Status.Phase != operatorv1.ConfigActive || config.Status.Message != message {
+		patch := client.MergeFrom(config.DeepCopy())
+		config.Status.Phase = operatorv1.ConfigActive
+		config.Status.Message = message
+		if err := r.Status().Patch(ctx, config, patch); err != nil {
+			return ctrl.Result{}, err
 		}
-
-		fmt.Println(manifests.String())
 	}
+	if argsErr != nil {
+		// retried on the next spec change
+		logger.Error(argsErr, "invalid kubearmor arguments")
+		r.Recorder.Eventf(config, corev1.EventTypeWarning, defaults.ReleaseFailedReason, "%s", message)
+		return ctrl.Result{}, nil
+	}
+	r.helmController.UpdateHelmValuesFromKubeArmorConfig(config)
+	r.helmController.UpdateUserHelmValues(userValues)
+	r.helmController.RequestUpgrade(fmt.Sprintf("kubearmorconfig %s changed", config.Name))
 	return ctrl.Result{}, nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// ignore marks a kubearmorconfig that is not the active one as ignored, its
+// spec is not applied until the active kubearmorconfig is deleted
+func (r *KubeArmorConfigReconciler) ignore(ctx context.Context, config, active *operatorv1.KubeArmorConfig) error {
+	message := "another kubearmorconfig is active"
+	if active != nil {
+		message = fmt.Sprintf("kubearmorconfig %s/%s is active, only the oldest kubearmorconfig configures KubeArmor", active.Namespace, active.Name)
+	}
+	if config.Status.Phase == operatorv1.ConfigIgnored && config.Status.Message == message {
+		return nil
+	}
+	log.FromContext(ctx).Info("ignoring kubearmorconfig", "reason", message)
+	r.Recorder.Eventf(config, corev1.EventTypeWarning, defaults.ConfigIgnoredReason, "ignoring kubearmorconfig: %s", message)
+	patch := client.MergeFrom(config.DeepCopy())
+	config.Status.Phase = operatorv1.ConfigIgnored
+	config.Status.Message = message
+	return r.Status().Patch(ctx, config, patch)
+}
+
+// reportUpgrade reports the progress of asynchronous upgrades in the status of
+// the kubearmorconfig they were made from. Deferred changes are reported as
+// pending until an upgrade succeeds
+func (r *KubeArmorConfigReconciler) reportUpgrade(result helm.UpgradeResult) {
+	if result.Config.Name == "" {
+		return
+	}
+	ctx := context.Background()
+	logger := log.FromContext(ctx).WithValues("kubearmorconfig", result.Config)
+	config := &operatorv1.KubeArmorConfig{}
+	if err := r.Get(ctx, result.Config, config); err != nil {
+		if !errors.IsNotFound(err) {
+			logger.Error(err, "unable to get kubearmorconfig to report upgrade status")
+		}
+		return
+	}
+
+	patch := client.MergeFrom(config.DeepCopy())
+	upgrade := &operatorv1.UpgradeStatus{
+		Phase:     result.Phase,
+		StartTime: &metav1.Time{Time: result.Started},
+		Reasons:   result.Reasons,
+	}
+	if current := config.Status.Upgrade; current != nil {
+		upgrade.Revision = current.Revision
+	}
+	if result.Release != nil {
+		upgrade.Revision = result.Release.Version
+	}
+	if !result.Finished.IsZero() {
+		upgrade.CompletionTime = &metav1.Time{Time: result.Finished}
+	}
+	if result.Err != nil {
+		upgrade.Message = result.Err.Error()
+	}
+	config.Status.Upgrade = upgrade
+	switch result.Phase {
+	case operatorv1.UpgradeDeferred:
+		if deferred, ok := result.Err.(*helm.DeferredError); ok {
+			config.Status.Pending = pendingStatus(config.Status.Pending, deferred)
+			logger.Info("changes pending", "reason", deferred.Reason, "nextWindow", config.Status.Pending.NextWindow)
+		}
+	case operatorv1.UpgradeSucceeded:
+		config.Status.Pending = nil
+	}
+	if err := r.Status().Patch(ctx, config, patch); err != nil {
+		logger.Error(err, "unable to update upgrade status", "phase", result.Phase)
+	}
+}
+
+// pendingStatus reports deferred changes, keeping the time they were first
+// deferred at
+func pendingStatus(current *operatorv1.PendingStatus, deferred *helm.DeferredError) *operatorv1.PendingStatus {
+	pending := &operatorv1.PendingStatus{Reason: deferred.Reason, Since: metav1.Now()}
+	if current != nil {
+		pending.Since = current.Since
+	}
+	if !deferred.Next.IsZero() {
+		pending.NextWindow = &metav1.Time{Time: deferred.Next}
+	}
+	return pending
+}
+
+// ResolveUserValues reads the helm values referenced by valuesFrom and merges them
+// in order, followed by the inline values. Lists of valuesListMerge are merged
+// by key between them as well
+func ResolveUserValues(ctx context.Context, r client.Reader, config *operatorv1.KubeArmorConfig) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if len(config.Spec.ValuesFrom) > 0 && r == nil {
+		return nil, fmt.Errorf("valuesFrom requires access to the cluster")
+	}
+	for _, ref := range config.Spec.ValuesFrom {
+		key := ref.Key
+		if key == "" {
+			key = "values.yaml"
+		}
+		var data []byte
+		var found bool
+		switch ref.Kind {
+		case "ConfigMap":
+			cm := &corev1.ConfigMap{}
+			err := r.Get(ctx, types.NamespacedName{Namespace: config.Namespace, Name: ref.Name}, cm)
+			if err != nil && !errors.IsNotFound(err) {
+				return nil, err
+			}
+			if err == nil {
+				if val, ok := cm.Data[key]; ok {
+					data, found = []byte(val), true
+				} else if val, ok := cm.BinaryData[key]; ok {
+					data, found = val, true
+				}
+			}
+		case "Secret":
+			secret := &corev1.Secret{}
+			err := r.Get(ctx, types.NamespacedName{Namespace: config.Namespace, Name: ref.Name}, secret)
+			if err != nil && !errors.IsNotFound(err) {
+				return nil, err
+			}
+			if err == nil {
+				data, found = secret.Data[key]
+			}
+		default:
+			return nil, fmt.Errorf("unsupported valuesFrom kind %s", ref.Kind)
+		}
+		if !found {
+			if ref.Optional {
+				continue
+			}
+			return nil, fmt.Errorf("key %s of %s %s/%s not found", key, ref.Kind, config.Namespace, ref.Name)
+		}
+		refValues := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &refValues); err != nil {
+			return nil, fmt.Errorf("error parsing values from %s %s/%s: %s", ref.Kind, config.Namespace, ref.Name, err.Error())
+		}
+		values = helm.MergeValues(values, refValues, config.Spec)
+	}
+	if config.Spec.Values != nil && len(config.Spec.Values.Raw) > 0 {
+		inlineValues := map[string]interface{}{}
+		if err := json.Unmarshal(config.Spec.Values.Raw, &inlineValues); err != nil {
+			return nil, fmt.Errorf("error parsing spec.values: %s", err.Error())
+		}
+		values = helm.MergeValues(values, inlineValues, config.Spec)
+	}
+	return values, nil
+}
+
+// kubeArmorConfigsForValuesSource maps a ConfigMap or Secret to the KubeArmorConfig
+// instances referencing it through valuesFrom
+func (r *KubeArmorConfigReconciler) kubeArmorConfigsForValuesSource(kind string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		configs := &operatorv1.KubeArmorConfigList{}
+		if err := r.List(ctx, configs, client.InNamespace(obj.GetNamespace())); err != nil {
+			return nil
+		}
+		var requests []reconcile.Request
+		for _, config := range configs.Items {
+			for _, ref := range config.Spec.ValuesFrom {
+				if ref.Kind == kind && ref.Name == obj.GetName() {
+					requests = append(requests, reconcile.Request{
+						NamespacedName: types.NamespacedName{Namespace: config.Namespace, Name: config.Name},
+					})
+					break
+				}
+			}
+		}
+		return requests
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager. ConfigMaps and
+// Secrets are watched by metadata only so that their content isn't cached
 func (r *KubeArmorConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.APIReader == nil {
+		r.APIReader = mgr.GetAPIReader()
+	}
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&operatorv1.KubeArmorConfig{}).
-		WithEventFilter(predicate.Funcs{
+		For(&operatorv1.KubeArmorConfig{}, builder.WithPredicates(predicate.Funcs{
 			UpdateFunc: func(ue event.UpdateEvent) bool {
 				oldConfig := ue.ObjectOld.(*operatorv1.KubeArmorConfig)
 				newConfig := ue.ObjectNew.(*operatorv1.KubeArmorConfig)
-				return !reflect.DeepEqual(oldConfig.Spec, newConfig.Spec)
+				return !reflect.DeepEqual(oldConfig.Spec, newConfig.Spec) ||
+					oldConfig.Annotations[defaults.PausedAnnotation] != newConfig.Annotations[defaults.PausedAnnotation]
 			},
-		}).
+		})).
+		// another instance becomes active when the active one is deleted
+		Watches(&operatorv1.KubeArmorConfig{}, handler.EnqueueRequestsFromMapFunc(allKubeArmorConfigs(r.Client)),
+			builder.WithPredicates(predicate.Funcs{
+				CreateFunc:  func(event.CreateEvent) bool { return false },
+				UpdateFunc:  func(event.UpdateEvent) bool { return false },
+				GenericFunc: func(event.GenericEvent) bool { return false },
+			})).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.kubeArmorConfigsForValuesSource("ConfigMap")),
+			builder.OnlyMetadata).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.kubeArmorConfigsForValuesSource("Secret")),
+			builder.OnlyMetadata).
 		Complete(r)
 }
@@ -1,14 +1,22 @@
 package controller
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"os"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/helm"
-	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 // OperatorConfig injects operator configurations
@@ -19,6 +27,8 @@ type OperatorConfig struct {
 	Repository string
 	// chart directory if local chart
 	Directory string
+	// directory to cache pulled charts, may be backed by a persistent volume
+	ChartCacheDir string
 	// chart name or chartRef
 	ChartName string
 	// namespace to deploy chart
@@ -29,13 +39,31 @@ type OperatorConfig struct {
 	OperatorDeploymentName string
 	// operator deployment uid
 	OperatorDeploymentUID string
+	// operator pod used to discover the operator deployment if its uid is not set
+	PodName string
+	// namespace the operator runs in, defaults to Namespace
+	PodNamespace string
+	// snitch image and its pull policy
+	SnitchImage           string
+	SnitchImagePullPolicy string
+	// timeout for helm install and upgrade operations
+	HelmTimeout time.Duration
+	// wait for release resources to become ready
+	HelmWait bool
+	// helm storage driver and number of release revisions kept
+	HelmStorageDriver string
+	HelmMaxHistory    int
+	// disables caching pulled charts
+	DisableChartCache bool
+	// adopt resources of installations older than v1.3.8 instead of deleting them
+	AdoptLegacyResources bool
 }
 
 // Operator repesents operator implementation
 type Operator struct {
 	k8sClient                 client.Client
 	k8sClientSet              *kubernetes.Clientset
-	log                       *zap.SugaredLogger
+	log                       logr.Logger
 	clusterWatcher            *ClusterWatcher
 	helmInstaller             *helm.Controller
 	kubeArmorConfigReconciler *KubeArmorConfigReconciler
@@ -44,18 +72,38 @@ type Operator struct {
 
 // NewOperator initializes and returns an operator instance
 func NewOperator(cfg OperatorConfig, k8sClient client.Client, k8sClientSet *kubernetes.Clientset, manager ctrl.Manager) (*Operator, error) {
-	logger, _ := zap.NewProduction()
-	log := logger.With(zap.String("component", "operator")).Sugar()
+	log := ctrl.Log.WithName("operator")
 
-	log.Infof("operator has been configured %+v", cfg)
+	if err := cfg.resolveOperatorDeployment(context.Background(), k8sClientSet); err != nil {
+		log.Error(err, "unable to discover operator deployment, created resources won't be owned by it")
+	}
+
+	log.Info("operator has been configured", "config", cfg)
+
+	recorder := manager.GetEventRecorderFor("kubearmor-operator")
 
 	// helm controller
 	helmConfig := helm.Config{
-		ChartName:  cfg.ChartName,
-		Namespace:  cfg.Namespace,
-		Version:    cfg.Version,
-		Repository: cfg.Repository,
-		Directory:  cfg.Directory,
+		ChartName:            cfg.ChartName,
+		Namespace:            cfg.Namespace,
+		Version:              cfg.Version,
+		Repository:           cfg.Repository,
+		Directory:            cfg.Directory,
+		CacheDir:             cfg.ChartCacheDir,
+		DisableChartCache:    cfg.DisableChartCache,
+		AdoptLegacyResources: cfg.AdoptLegacyResources,
+		Timeout:              cfg.HelmTimeout,
+		Wait:                 cfg.HelmWait,
+		StorageDriver:        cfg.HelmStorageDriver,
+		MaxHistory:           cfg.HelmMaxHistory,
+		EventRecorder:        recorder,
+		EventObject: &corev1.ObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       cfg.OperatorDeploymentName,
+			Namespace:  cfg.PodNamespace,
+			UID:        types.UID(cfg.OperatorDeploymentUID),
+		},
 	}
 
 	helmController, err := helm.NewHelmController(helmConfig)
@@ -65,20 +113,25 @@ func NewOperator(cfg OperatorConfig, k8sClient client.Client, k8sClientSet *kube
 
 	// cluster watcher
 	watcherConfig := WatcherConfig{
-		SnitchPathPrefix:         cfg.SnitchPathPrefix,
-		OperatorWatchedNamespace: cfg.Namespace,
-		OperatorDeploymentName:   cfg.OperatorDeploymentName,
-		OperatorDeploymentUID:    cfg.OperatorDeploymentUID,
+		SnitchPathPrefix:            cfg.SnitchPathPrefix,
+		SnitchImage:                 cfg.SnitchImage,
+		SnitchImagePullPolicy:       cfg.SnitchImagePullPolicy,
+		OperatorWatchedNamespace:    cfg.Namespace,
+		OperatorDeploymentName:      cfg.OperatorDeploymentName,
+		OperatorDeploymentNamespace: cfg.PodNamespace,
+		OperatorDeploymentUID:       cfg.OperatorDeploymentUID,
 	}
 
-	clusterWatcher, err := NewClusterWatcher(watcherConfig, k8sClientSet, helmController)
+	clusterWatcher, err := NewClusterWatcher(watcherConfig, k8sClientSet, helmController, recorder)
 	if err != nil {
 		return nil, err
 	}
 	kubeArmorConfigReconciler := KubeArmorConfigReconciler{
-		helmController,
-		k8sClient,
-		k8sClient.Scheme(),
+		helmController: helmController,
+		Client:         k8sClient,
+		Scheme:         k8sClient.Scheme(),
+		Recorder:       recorder,
+		APIReader:      manager.GetAPIReader(),
 	}
 
 	return &Operator{
@@ -92,31 +145,145 @@ func NewOperator(cfg OperatorConfig, k8sClient client.Client, k8sClientSet *kube
 	}, nil
 }
 
+// resolveOperatorDeployment defaults the operator namespace to the release
+// namespace and discovers the operator deployment from the operator pod if its
+// uid is not set
+func (cfg *OperatorConfig) resolveOperatorDeployment(ctx context.Context, clientset kubernetes.Interface) error {
+	if cfg.PodNamespace == "" {
+		cfg.PodNamespace = cfg.Namespace
+	}
+	if cfg.OperatorDeploymentUID != "" || cfg.PodName == "" {
+		return nil
+	}
+	name, uid, err := discoverOperatorDeployment(ctx, clientset, cfg.PodNamespace, cfg.PodName)
+	if err != nil {
+		return err
+	}
+	cfg.OperatorDeploymentName, cfg.OperatorDeploymentUID = name, uid
+	return nil
+}
+
+// discoverOperatorDeployment follows the owner references of the operator pod
+// through its replicaset to the deployment and returns its name and uid
+func discoverOperatorDeployment(ctx context.Context, clientset kubernetes.Interface, namespace, podName string) (string, string, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
+	}
+	rsRef := metav1.GetControllerOf(pod)
+	if rsRef == nil || rsRef.Kind != "ReplicaSet" {
+		return "", "", fmt.Errorf("operator pod %s is not controlled by a replicaset", podName)
+	}
+	rs, err := clientset.AppsV1().ReplicaSets(namespace).Get(ctx, rsRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
+	}
+	deployRef := metav1.GetControllerOf(rs)
+	if deployRef == nil || deployRef.Kind != "Deployment" {
+		return "", "", fmt.Errorf("replicaset %s is not controlled by a deployment", rs.Name)
+	}
+	return deployRef.Name, string(deployRef.UID), nil
+}
+
+// informerSyncCheck reports whether the manager cache and the cluster watcher
+// informers have synced
+func (operator *Operator) informerSyncCheck(req *http.Request) error {
+	ctx, cancel := context.WithTimeout(req.Context(), time.Second)
+	defer cancel()
+	if !operator.controllerManager.GetCache().WaitForCacheSync(ctx) {
+		return fmt.Errorf("manager cache has not synced")
+	}
+	return operator.clusterWatcher.SyncCheck(req)
+}
+
+// release revisions and the support bundle are stored in the operator namespace
+//+kubebuilder:rbac:groups="",namespace=system,resources=configmaps;secrets,verbs=create;update;delete
+
 // Start runs operator componenets
 func (operator *Operator) Start() {
 	err := operator.helmInstaller.Preinstall()
 	if err != nil {
-		operator.log.Errorf("error while cleaning up existing release", err.Error())
+		operator.log.Error(err, "error while migrating the existing installation")
 	}
 
 	// start cluster(node)watcher
 	go operator.clusterWatcher.WatchNodes()
+	go operator.clusterWatcher.WatchSnitchJobs()
 
 	// start kubeconfigreconciler
 	if err = operator.kubeArmorConfigReconciler.SetupWithManager(operator.controllerManager); err != nil {
 		operator.log.Error(err, "unable to create controller", "controller", "KubeArmorConfig")
 		os.Exit(1)
 	}
-	//+kubebuilder:scaffold:builder
-
-	if err := operator.controllerManager.AddHealthzCheck("healthz", healthz.Ping); err != nil {
-		operator.log.Error(err, "unable to set up health check")
+	if err = (&PreflightReconciler{operator.k8sClient}).SetupWithManager(operator.controllerManager); err != nil {
+		operator.log.Error(err, "unable to create controller", "controller", "Preflight")
+		os.Exit(1)
+	}
+	supportBundleReconciler := &SupportBundleReconciler{
+		Client:         operator.k8sClient,
+		Clientset:      operator.k8sClientSet,
+		HelmController: operator.helmInstaller,
+		Namespace:      operatorWatchedNamespace,
+		Recorder:       operator.kubeArmorConfigReconciler.Recorder,
+	}
+	if err = supportBundleReconciler.SetupWithManager(operator.controllerManager); err != nil {
+		operator.log.Error(err, "unable to create controller", "controller", "SupportBundle")
+		os.Exit(1)
+	}
+	if err = (&NodeProfileReconciler{Client: operator.k8sClient, Releases: operator.helmInstaller}).SetupWithManager(operator.controllerManager); err != nil {
+		operator.log.Error(err, "unable to create controller", "controller", "NodeProfile")
+		os.Exit(1)
+	}
+	if err = (&NamespacePostureReconciler{operator.k8sClient}).SetupWithManager(operator.controllerManager); err != nil {
+		operator.log.Error(err, "unable to create controller", "controller", "NamespacePosture")
 		os.Exit(1)
 	}
-	if err := operator.controllerManager.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		operator.log.Error(err, "unable to set up ready check")
+	rolloutReconciler := &RolloutReconciler{
+		Client:    operator.k8sClient,
+		Clientset: operator.k8sClientSet,
+		Releases:  operator.helmInstaller,
+		Namespace: operatorWatchedNamespace,
+		Recorder:  operator.kubeArmorConfigReconciler.Recorder,
+	}
+	if err = rolloutReconciler.SetupWithManager(operator.controllerManager); err != nil {
+		operator.log.Error(err, "unable to create controller", "controller", "Rollout")
 		os.Exit(1)
 	}
+	// helm upgrades run in the background so that slow rollouts don't block
+	// the reconcilers and the node watcher
+	operator.helmInstaller.OnUpgrade(operator.kubeArmorConfigReconciler.reportUpgrade)
+	operator.helmInstaller.OnUpgrade(operator.clusterWatcher.trackUpgrade)
+	if err = operator.controllerManager.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		operator.helmInstaller.RunUpgrades(ctx)
+		return nil
+	})); err != nil {
+		operator.log.Error(err, "unable to add helm upgrade worker")
+		os.Exit(1)
+	}
+	//+kubebuilder:scaffold:builder
+
+	healthzChecks := map[string]healthz.Checker{
+		"healthz":        healthz.Ping,
+		"helm-operation": operator.helmInstaller.OperationCheck,
+	}
+	for name, check := range healthzChecks {
+		if err := operator.controllerManager.AddHealthzCheck(name, check); err != nil {
+			operator.log.Error(err, "unable to set up health check", "check", name)
+			os.Exit(1)
+		}
+	}
+	readyzChecks := map[string]healthz.Checker{
+		"readyz":        healthz.Ping,
+		"informer-sync": operator.informerSyncCheck,
+		"chart-loaded":  operator.helmInstaller.ChartCheck,
+		"helm-storage":  operator.helmInstaller.StorageCheck,
+	}
+	for name, check := range readyzChecks {
+		if err := operator.controllerManager.AddReadyzCheck(name, check); err != nil {
+			operator.log.Error(err, "unable to set up ready check", "check", name)
+			os.Exit(1)
+		}
+	}
 
 	operator.log.Info("starting manager")
 	if err := operator.controllerManager.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestResolveOperatorDeployment(t *testing.T) {
+	controllerOf := func(kind, name, uid string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: name, UID: types.UID(uid), Controller: ptr.To(true)}}
+	}
+	// the operator runs outside the kubearmor release namespace
+	clientset := fake.NewSimpleClientset(
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "kubearmor-operator-5d4f", Namespace: "operators",
+			OwnerReferences: controllerOf("Deployment", "kubearmor-operator", "1234")}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "kubearmor-operator-5d4f-x2x9k", Namespace: "operators",
+			OwnerReferences: controllerOf("ReplicaSet", "kubearmor-operator-5d4f", "5678")}},
+	)
+
+	cfg := OperatorConfig{Namespace: "kubearmor", PodName: "kubearmor-operator-5d4f-x2x9k", PodNamespace: "operators"}
+	assert.NoError(t, cfg.resolveOperatorDeployment(context.Background(), clientset))
+	assert.Equal(t, "kubearmor-operator", cfg.OperatorDeploymentName)
+	assert.Equal(t, "1234", cfg.OperatorDeploymentUID)
+
+	// without the operator namespace the pod is looked up in the release namespace
+	cfg = OperatorConfig{Namespace: "kubearmor", PodName: "kubearmor-operator-5d4f-x2x9k"}
+	assert.Error(t, cfg.resolveOperatorDeployment(context.Background(), clientset))
+	assert.Equal(t, "kubearmor", cfg.PodNamespace)
+	assert.Empty(t, cfg.OperatorDeploymentUID)
+}
+
+func TestAddOwnershipAcrossNamespaces(t *testing.T) {
+	defer func(name, uid, namespace, watched string) {
+		operatorDeploymentName, operatorDeploymentUID, operatorDeploymentNamespace, operatorWatchedNamespace = name, uid, namespace, watched
+	}(operatorDeploymentName, operatorDeploymentUID, operatorDeploymentNamespace, operatorWatchedNamespace)
+	operatorDeploymentName, operatorDeploymentUID = "kubearmor-operator", "1234"
+	operatorDeploymentNamespace, operatorWatchedNamespace = "operators", "kubearmor"
+
+	sa := addOwnership(&corev1.ServiceAccount{}).(*corev1.ServiceAccount)
+	assert.Empty(t, sa.OwnerReferences)
+	role := addOwnership(&rbacv1.ClusterRole{}).(*rbacv1.ClusterRole)
+	assert.Len(t, role.OwnerReferences, 1)
+
+	operatorDeploymentNamespace = "kubearmor"
+	sa = addOwnership(&corev1.ServiceAccount{}).(*corev1.ServiceAccount)
+	assert.Len(t, sa.OwnerReferences, 1)
+}
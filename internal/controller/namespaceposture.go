@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+)
+
+// NamespacePostureAnnotations returns the KubeArmor posture annotations of the
+// first namespace posture selecting the namespace labels
+func NamespacePostureAnnotations(postures []operatorv1.NamespacePosture, namespaceLabels map[string]string) (map[string]string, error) {
+	annotations := map[string]string{}
+	for i, posture := range postures {
+		selector, err := metav1.LabelSelectorAsSelector(&posture.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector of namespace posture %d: %s", i, err.Error())
+		}
+		if !selector.Matches(labels.Set(namespaceLabels)) {
+			continue
+		}
+		for key, value := range map[string]string{
+			defaults.FilePostureAnnotation:         string(posture.FilePosture),
+			defaults.CapabilitiesPostureAnnotation: string(posture.CapabilitiesPosture),
+			defaults.NetworkPostureAnnotation:      string(posture.NetworkPosture),
+			defaults.VisibilityAnnotation:          posture.Visibility,
+		} {
+			if value != "" {
+				annotations[key] = value
+			}
+		}
+		break
+	}
+	return annotations, nil
+}
+
+// NamespacePostureReconciler annotates namespaces with the postures and
+// visibility of the namespace postures of the active kubearmorconfig. The
+// annotations it sets are listed in the managed postures annotation, so that
+// they are removed with the namespace posture while others are left alone
+type NamespacePostureReconciler struct {
+	client.Client
+}
+
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;patch
+
+// Reconcile updates the posture annotations of a namespace
+func (r *NamespacePostureReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, req.NamespacedName, namespace); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !namespace.GetDeletionTimestamp().IsZero() {
+		return ctrl.Result{}, nil
+	}
+	var postures []operatorv1.NamespacePosture
+	active, err := activeKubeArmorConfig(ctx, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if active != nil {
+		postures = active.Spec.NamespacePostures
+	}
+	desired, err := NamespacePostureAnnotations(postures, namespace.Labels)
+	if err != nil {
+		// retried on the next spec change
+		logger.Error(err, "unable to select namespace posture")
+		return ctrl.Result{}, nil
+	}
+
+	patch := client.MergeFrom(namespace.DeepCopy())
+	if !setPostureAnnotations(namespace, desired) {
+		return ctrl.Result{}, nil
+	}
+	if err := r.Patch(ctx, namespace, patch); err != nil {
+		return ctrl.Result{}, err
+	}
+	logger.Info("updated namespace postures", "annotations", desired)
+	return ctrl.Result{}, nil
+}
+
+// setPostureAnnotations replaces the annotations previously set by the
+// operator with the desired ones and reports whether the namespace changed.
+// Posture annotations set by users are left alone and take precedence
+func setPostureAnnotations(namespace *corev1.Namespace, desired map[string]string) bool {
+	annotations := namespace.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	managed := map[string]bool{}
+	if list := annotations[defaults.ManagedPosturesAnnotation]; list != "" {
+		for _, key := range strings.Split(list, ",") {
+			managed[key] = true
+		}
+	}
+	changed := false
+	for key := range managed {
+		if _, ok := desired[key]; !ok {
+			if _, ok := annotations[key]; ok {
+				delete(annotations, key)
+				changed = true
+			}
+			delete(managed, key)
+		}
+	}
+	for key, value := range desired {
+		current, ok := annotations[key]
+		if ok && !managed[key] {
+			continue
+		}
+		managed[key] = true
+		if current != value {
+			annotations[key] = value
+			changed = true
+		}
+	}
+	keys := make([]string, 0, len(managed))
+	for key := range managed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := strings.Join(keys, ",")
+	if annotations[defaults.ManagedPosturesAnnotation] != list {
+		changed = true
+		if list == "" {
+			delete(annotations, defaults.ManagedPosturesAnnotation)
+		} else {
+			annotations[defaults.ManagedPosturesAnnotation] = list
+		}
+	}
+	namespace.SetAnnotations(annotations)
+	return changed
+}
+
+// namespacesForKubeArmorConfig maps kubearmorconfig changes to all namespaces
+func (r *NamespacePostureReconciler) namespacesForKubeArmorConfig(ctx context.Context, _ client.Object) []reconcile.Request {
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaces); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, namespace := range namespaces.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&namespace)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager. Namespaces are
+// reconciled when created and on label changes, and on annotation changes to
+// restore edited posture annotations
+func (r *NamespacePostureReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("namespaceposture").
+		For(&corev1.Namespace{}, builder.WithPredicates(predicate.Or(predicate.LabelChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Watches(&operatorv1.KubeArmorConfig{}, handler.EnqueueRequestsFromMapFunc(r.namespacesForKubeArmorConfig),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
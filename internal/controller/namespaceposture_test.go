@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+)
+
+func TestNamespacePostureAnnotations(t *testing.T) {
+	postures := []operatorv1.NamespacePosture{
+		{NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}, FilePosture: "block", NetworkPosture: "block"},
+		{FilePosture: "audit", Visibility: "process"},
+	}
+	annotations, err := NamespacePostureAnnotations(postures, map[string]string{"env": "prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		defaults.FilePostureAnnotation:    "block",
+		defaults.NetworkPostureAnnotation: "block",
+	}, annotations)
+
+	// an empty selector selects all namespaces
+	annotations, err = NamespacePostureAnnotations(postures, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		defaults.FilePostureAnnotation: "audit",
+		defaults.VisibilityAnnotation:  "process",
+	}, annotations)
+
+	annotations, err = NamespacePostureAnnotations(postures[:1], nil)
+	assert.NoError(t, err)
+	assert.Empty(t, annotations)
+
+	_, err = NamespacePostureAnnotations([]operatorv1.NamespacePosture{{NamespaceSelector: metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: "Near"}},
+	}}}, nil)
+	assert.Error(t, err)
+}
+
+func TestNamespacePostureReconciler(t *testing.T) {
+	ctx := context.Background()
+	config := testKubeArmorConfig("kubearmor", "kubearmorconfig-default", time.Now())
+	config.Spec.NamespacePostures = []operatorv1.NamespacePosture{
+		{NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}, FilePosture: "block"},
+	}
+	prod := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod", Labels: map[string]string{"env": "prod"},
+		Annotations: map[string]string{defaults.VisibilityAnnotation: "none"}}}
+	// the posture set by the user is kept and not taken over
+	payments := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"env": "prod"},
+		Annotations: map[string]string{defaults.FilePostureAnnotation: "audit"}}}
+	// no longer selected, the annotations set by the operator are removed
+	dev := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", Annotations: map[string]string{
+		defaults.FilePostureAnnotation:     "block",
+		defaults.NetworkPostureAnnotation:  "audit",
+		defaults.ManagedPosturesAnnotation: defaults.FilePostureAnnotation,
+	}}}
+	r := &NamespacePostureReconciler{testClient(t, config, prod, payments, dev)}
+
+	reconcileObjects(t, r, prod, payments, dev)
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(prod), prod))
+	assert.Equal(t, map[string]string{
+		defaults.FilePostureAnnotation:     "block",
+		defaults.VisibilityAnnotation:      "none",
+		defaults.ManagedPosturesAnnotation: defaults.FilePostureAnnotation,
+	}, prod.Annotations)
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(payments), payments))
+	assert.Equal(t, map[string]string{defaults.FilePostureAnnotation: "audit"}, payments.Annotations)
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(dev), dev))
+	assert.Equal(t, map[string]string{defaults.NetworkPostureAnnotation: "audit"}, dev.Annotations)
+	assert.Len(t, r.namespacesForKubeArmorConfig(ctx, config), 3)
+
+	// edits to managed postures are reverted, removing the posture of the
+	// user hands it back to the operator
+	prod.Annotations[defaults.FilePostureAnnotation] = "audit"
+	assert.NoError(t, r.Update(ctx, prod))
+	delete(payments.Annotations, defaults.FilePostureAnnotation)
+	assert.NoError(t, r.Update(ctx, payments))
+	reconcileObjects(t, r, prod, payments)
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(prod), prod))
+	assert.Equal(t, "block", prod.Annotations[defaults.FilePostureAnnotation])
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(payments), payments))
+	assert.Equal(t, map[string]string{
+		defaults.FilePostureAnnotation:     "block",
+		defaults.ManagedPosturesAnnotation: defaults.FilePostureAnnotation,
+	}, payments.Annotations)
+}
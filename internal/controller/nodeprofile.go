@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/helm"
+)
+
+// NodeProfileFor returns the name of the first profile selecting the node
+// labels, empty if there is none. The profile label itself is not matched
+func NodeProfileFor(profiles []operatorv1.NodeProfile, nodeLabels map[string]string) (string, error) {
+	set := labels.Set{}
+	for key, value := range nodeLabels {
+		if key != defaults.ProfileLabel {
+			set[key] = value
+		}
+	}
+	for _, profile := range profiles {
+		selector, err := metav1.LabelSelectorAsSelector(&profile.NodeSelector)
+		if err != nil {
+			return "", fmt.Errorf("invalid node selector of profile %s: %s", profile.Name, err.Error())
+		}
+		// an empty selector would select all nodes, leaving none to the
+		// default daemonsets
+		if !selector.Empty() && selector.Matches(set) {
+			return profile.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// nodeProfileReleases is the part of the helm controller node profiles use
+type nodeProfileReleases interface {
+	Release() (*release.Release, error)
+}
+
+// NodeProfileReconciler labels nodes with the node profile of the active
+// kubearmorconfig selecting them, the profile daemonsets select nodes by it.
+// The other daemonsets skip labeled nodes, so only profiles the deployed
+// release has daemonsets for are used
+type NodeProfileReconciler struct {
+	client.Client
+	Releases nodeProfileReleases
+
+	mutex sync.Mutex
+	// deployed are the profiles of the latest release revision, nil until
+	// loaded and whenever profile daemonsets are created or deleted
+	deployed map[string]bool
+}
+
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;patch
+
+// Reconcile updates the profile label of a node
+func (r *NodeProfileReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	node := &corev1.Node{}
+	if err := r.Get(ctx, req.NamespacedName, node); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	var profiles []operatorv1.NodeProfile
+	active, err := activeKubeArmorConfig(ctx, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if active != nil {
+		deployed, err := r.deployedProfiles()
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		for _, profile := range active.Spec.NodeProfiles {
+			if deployed[profile.Name] {
+				profiles = append(profiles, profile)
+			}
+		}
+	}
+	profile, err := NodeProfileFor(profiles, node.Labels)
+	if err != nil {
+		// retried on the next spec change
+		logger.Error(err, "unable to select node profile")
+		return ctrl.Result{}, nil
+	}
+	if node.Labels[defaults.ProfileLabel] == profile {
+		return ctrl.Result{}, nil
+	}
+
+	patch := client.MergeFrom(node.DeepCopy())
+	if profile == "" {
+		delete(node.Labels, defaults.ProfileLabel)
+	} else {
+		if node.Labels == nil {
+			node.Labels = map[string]string{}
+		}
+		node.Labels[defaults.ProfileLabel] = profile
+	}
+	if err := r.Patch(ctx, node, patch); err != nil {
+		return ctrl.Result{}, err
+	}
+	logger.Info("updated node profile", "profile", profile)
+	return ctrl.Result{}, nil
+}
+
+// deployedProfiles returns the profiles the latest release revision deploys
+// daemonsets for
+func (r *NodeProfileReconciler) deployedProfiles() (map[string]bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.deployed != nil {
+		return r.deployed, nil
+	}
+	rel, err := r.Releases.Release()
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return map[string]bool{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	deployed, err := helm.ReleaseNodeProfiles(rel)
+	if err != nil {
+		return nil, err
+	}
+	r.deployed = deployed
+	return deployed, nil
+}
+
+// nodesForProfileDaemonSet maps the creation and deletion of profile
+// daemonsets by upgrades and rollbacks to all nodes, reloading the profiles
+// of the release
+func (r *NodeProfileReconciler) nodesForProfileDaemonSet(ctx context.Context, obj client.Object) []reconcile.Request {
+	r.mutex.Lock()
+	r.deployed = nil
+	r.mutex.Unlock()
+	return r.nodesForKubeArmorConfig(ctx, obj)
+}
+
+// nodesForKubeArmorConfig maps kubearmorconfig changes to all nodes
+func (r *NodeProfileReconciler) nodesForKubeArmorConfig(ctx context.Context, _ client.Object) []reconcile.Request {
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, node := range nodes.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&node)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager, nodes are only
+// watched for label changes as node status is updated continuously
+func (r *NodeProfileReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	profileDaemonSets, err := predicate.LabelSelectorPredicate(metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: defaults.ProfileLabel, Operator: metav1.LabelSelectorOpExists}},
+	})
+	if err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("nodeprofile").
+		For(&corev1.Node{}, builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Watches(&operatorv1.KubeArmorConfig{}, handler.EnqueueRequestsFromMapFunc(r.nodesForKubeArmorConfig),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&appsv1.DaemonSet{}, handler.EnqueueRequestsFromMapFunc(r.nodesForProfileDaemonSet),
+			builder.WithPredicates(profileDaemonSets, predicate.Funcs{
+				UpdateFunc: func(event.UpdateEvent) bool { return false },
+			})).
+		Complete(r)
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+)
+
+// reconcileObjects reconciles each of the objects once
+func reconcileObjects(t *testing.T, r reconcile.Reconciler, objs ...client.Object) {
+	for _, obj := range objs {
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+		assert.NoError(t, err)
+	}
+}
+
+func TestNodeProfileFor(t *testing.T) {
+	profiles := []operatorv1.NodeProfile{
+		{Name: "gpu", NodeSelector: metav1.LabelSelector{MatchLabels: map[string]string{"pool": "gpu"}}},
+		{Name: "edge", NodeSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "topology.kubernetes.io/zone", Operator: metav1.LabelSelectorOpIn, Values: []string{"edge-1", "edge-2"}},
+		}}},
+		{Name: "all"},
+	}
+	tests := []struct {
+		labels  map[string]string
+		profile string
+	}{
+		{map[string]string{"pool": "gpu", "topology.kubernetes.io/zone": "edge-1"}, "gpu"},
+		{map[string]string{"topology.kubernetes.io/zone": "edge-2"}, "edge"},
+		// the label of the current profile is not matched
+		{map[string]string{defaults.ProfileLabel: "gpu"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		profile, err := NodeProfileFor(profiles, tt.labels)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.profile, profile, tt.labels)
+	}
+
+	_, err := NodeProfileFor([]operatorv1.NodeProfile{{Name: "invalid", NodeSelector: metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "pool", Operator: "Near"}},
+	}}}, nil)
+	assert.Error(t, err)
+}
+
+// testProfileRelease returns a release deploying daemonsets for the profiles
+func testProfileRelease(profiles ...string) *release.Release {
+	manifest := "---\n# Source: kubearmor/templates/daemonset.yaml\napiVersion: apps/v1\nkind: DaemonSet\nmetadata:\n  name: kubearmor-bpf-containerd-98c2c\n"
+	for _, profile := range profiles {
+		manifest += fmt.Sprintf("---\napiVersion: apps/v1\nkind: DaemonSet\nmetadata:\n  name: kubearmor-bpf-containerd-98c2c-%s\n  labels:\n    %s: %s\n",
+			profile, defaults.ProfileLabel, profile)
+	}
+	return &release.Release{Name: "kubearmor", Version: 1, Manifest: manifest}
+}
+
+type testProfileReleases struct {
+	release *release.Release
+}
+
+func (t *testProfileReleases) Release() (*release.Release, error) {
+	return t.release, nil
+}
+
+func TestNodeProfileReconciler(t *testing.T) {
+	ctx := context.Background()
+	config := testKubeArmorConfig("kubearmor", "kubearmorconfig-default", time.Now())
+	config.Spec.NodeProfiles = []operatorv1.NodeProfile{
+		{Name: "gpu", NodeSelector: metav1.LabelSelector{MatchLabels: map[string]string{"pool": "gpu"}}},
+		{Name: "edge", NodeSelector: metav1.LabelSelector{MatchLabels: map[string]string{"pool": "edge"}}},
+	}
+	gpuNode := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-1", Labels: map[string]string{"pool": "gpu"}}}
+	cpuNode := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cpu-1", Labels: map[string]string{defaults.ProfileLabel: "gpu"}}}
+	edgeNode := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "edge-1", Labels: map[string]string{"pool": "edge"}}}
+	releases := &testProfileReleases{release: testProfileRelease("gpu")}
+	r := &NodeProfileReconciler{Client: testClient(t, config, gpuNode, cpuNode, edgeNode), Releases: releases}
+
+	reconcileObjects(t, r, gpuNode, cpuNode, edgeNode)
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(gpuNode), gpuNode))
+	assert.Equal(t, "gpu", gpuNode.Labels[defaults.ProfileLabel])
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cpuNode), cpuNode))
+	assert.NotContains(t, cpuNode.Labels, defaults.ProfileLabel)
+	// the release has no daemonset for the profile yet, the node keeps
+	// running the default daemonset
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(edgeNode), edgeNode))
+	assert.NotContains(t, edgeNode.Labels, defaults.ProfileLabel)
+	assert.Len(t, r.nodesForKubeArmorConfig(ctx, config), 3)
+
+	// an upgrade creates the profile daemonset
+	releases.release = testProfileRelease("gpu", "edge")
+	assert.Len(t, r.nodesForProfileDaemonSet(ctx, config), 3)
+	reconcileObjects(t, r, edgeNode)
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(edgeNode), edgeNode))
+	assert.Equal(t, "edge", edgeNode.Labels[defaults.ProfileLabel])
+
+	// a rollback deletes them again
+	releases.release = testProfileRelease()
+	r.nodesForProfileDaemonSet(ctx, config)
+	reconcileObjects(t, r, gpuNode, edgeNode)
+	assert.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(gpuNode), gpuNode))
+	assert.NotContains(t, gpuNode.Labels, defaults.ProfileLabel)
+}
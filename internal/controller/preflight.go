@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+)
+
+// supportedEnforcers are the LSMs KubeArmor enforces policies with
+var supportedEnforcers = []string{"apparmor", "selinux", "bpf"}
+
+// maxPreflightStatusNodes caps the nodes listed in the kubearmorconfig status
+const maxPreflightStatusNodes = 20
+
+// NodePreflightReport evaluates how KubeArmor would run on the node from the
+// labels set by snitch. The chart selects daemonset nodes by kubernetes.io/arch
+// and a kubearmor.io/<key> label for every other node configuration key, a node
+// missing any of them is not selected by any daemonset
+func NodePreflightReport(nodeObj corev1.Node) operatorv1.NodePreflight {
+	n := nodeFromLabels(nodeObj.Labels)
+	report := operatorv1.NodePreflight{
+		Name:          nodeObj.Name,
+		Enforcer:      n.Enforcer,
+		BTF:           n.BTF,
+		Runtime:       n.Runtime,
+		Socket:        n.RuntimeSocket,
+		Arch:          n.Arch,
+		KernelVersion: nodeObj.Status.NodeInfo.KernelVersion,
+		Mode:          operatorv1.NodeModeNone,
+	}
+	if nodeObj.Labels[defaults.RandLabel] == "" {
+		report.Issues = append(report.Issues, "node configuration has not been detected by snitch")
+		return report
+	}
+	missing := []string{}
+	for key := range convertNodeStructToMapOfStringInterface(n) {
+		label := "kubearmor.io/" + key
+		if key == "arch" {
+			label = defaults.ArchLabel
+		}
+		if _, ok := nodeObj.Labels[label]; !ok {
+			missing = append(missing, label)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		report.Issues = append(report.Issues, fmt.Sprintf("no daemonset would select the node, missing labels %s", strings.Join(missing, ", ")))
+		return report
+	}
+
+	report.Matched = true
+	if slices.Contains(supportedEnforcers, n.Enforcer) {
+		report.Mode = operatorv1.NodeModeEnforce
+	} else {
+		report.Mode = operatorv1.NodeModeAudit
+		report.Issues = append(report.Issues, "no supported LSM is enabled, policy violations are only audited")
+	}
+	if n.BTF != "yes" {
+		report.Issues = append(report.Issues, "BTF is not available, kernel headers are required on the node")
+	}
+	if n.RuntimeSocket == "" {
+		report.Issues = append(report.Issues, "no container runtime socket was detected, containers won't be monitored")
+	}
+	return report
+}
+
+// Preflight evaluates the linux nodes, sorted by name
+func Preflight(nodes []corev1.Node) []operatorv1.NodePreflight {
+	reports := []operatorv1.NodePreflight{}
+	for _, nodeObj := range nodes {
+		if nodeObj.Labels[defaults.OsLabel] != "linux" {
+			continue
+		}
+		reports = append(reports, NodePreflightReport(nodeObj))
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Name < reports[j].Name })
+	return reports
+}
+
+// PreflightSummary summarizes node reports for the kubearmorconfig status, only
+// nodes that are not enforcing are listed
+func PreflightSummary(reports []operatorv1.NodePreflight) *operatorv1.PreflightStatus {
+	status := &operatorv1.PreflightStatus{}
+	for _, report := range reports {
+		switch {
+		case !report.Matched:
+			status.UnmatchedNodes++
+		case report.Mode == operatorv1.NodeModeEnforce:
+			status.EnforceNodes++
+			continue
+		default:
+			status.AuditNodes++
+		}
+		if len(status.Nodes) < maxPreflightStatusNodes {
+			status.Nodes = append(status.Nodes, report)
+		}
+	}
+	return status
+}
+
+// PreflightReconciler reports the KubeArmor compatibility of the cluster nodes
+// in the status of kubearmorconfig instances
+type PreflightReconciler struct {
+	client.Client
+}
+
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+
+// Reconcile updates the preflight status of a kubearmorconfig instance
+func (r *PreflightReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	config := &operatorv1.KubeArmorConfig{}
+	if err := r.Get(ctx, req.NamespacedName, config); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return ctrl.Result{}, err
+	}
+	preflight := PreflightSummary(Preflight(nodes.Items))
+	if current := config.Status.Preflight; current != nil {
+		preflight.LastUpdateTime = current.LastUpdateTime
+		if reflect.DeepEqual(current, preflight) {
+			return ctrl.Result{}, nil
+		}
+	}
+	preflight.LastUpdateTime = metav1.Now()
+
+	patch := client.MergeFrom(config.DeepCopy())
+	config.Status.Preflight = preflight
+	if err := r.Status().Patch(ctx, config, patch); err != nil {
+		return ctrl.Result{}, err
+	}
+	logger.V(1).Info("updated preflight status", "enforce", preflight.EnforceNodes,
+		"audit", preflight.AuditNodes, "unmatched", preflight.UnmatchedNodes)
+	return ctrl.Result{}, nil
+}
+
+// kubeArmorConfigsForNode maps node changes to all kubearmorconfig instances
+func (r *PreflightReconciler) kubeArmorConfigsForNode(ctx context.Context, _ client.Object) []reconcile.Request {
+	configs := &operatorv1.KubeArmorConfigList{}
+	if err := r.List(ctx, configs); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, config := range configs.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: config.Namespace, Name: config.Name},
+		})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager, nodes are only
+// watched for label changes as node status is updated continuously
+func (r *PreflightReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("preflight").
+		For(&operatorv1.KubeArmorConfig{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(r.kubeArmorConfigsForNode),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Complete(r)
+}
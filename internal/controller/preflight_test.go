@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+)
+
+func testNode(name string, labels map[string]string) corev1.Node {
+	nodeLabels := map[string]string{
+		defaults.OsLabel:         "linux",
+		defaults.ArchLabel:       "amd64",
+		defaults.RandLabel:       "abcd",
+		defaults.EnforcerLabel:   "apparmor",
+		defaults.RuntimeLabel:    "containerd",
+		defaults.SocketLabel:     "run_containerd_containerd.sock",
+		defaults.BTFLabel:        "yes",
+		defaults.ApparmorFsLabel: "yes",
+		defaults.SeccompLabel:    "yes",
+	}
+	for k, v := range labels {
+		if v == "" {
+			delete(nodeLabels, k)
+			continue
+		}
+		nodeLabels[k] = v
+	}
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KernelVersion: "6.1.0"}},
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	reports := Preflight([]corev1.Node{
+		testNode("enforce", nil),
+		testNode("audit", map[string]string{defaults.EnforcerLabel: "none", defaults.BTFLabel: "no"}),
+		testNode("undetected", map[string]string{defaults.RandLabel: ""}),
+		testNode("missing-seccomp", map[string]string{defaults.SeccompLabel: ""}),
+		testNode("windows", map[string]string{defaults.OsLabel: "windows"}),
+	})
+
+	assert.Equal(t, []string{"audit", "enforce", "missing-seccomp", "undetected"}, []string{
+		reports[0].Name, reports[1].Name, reports[2].Name, reports[3].Name,
+	})
+	assert.Len(t, reports, 4)
+
+	audit, enforce, missing, undetected := reports[0], reports[1], reports[2], reports[3]
+	assert.Equal(t, operatorv1.NodePreflight{
+		Name:          "enforce",
+		Enforcer:      "apparmor",
+		BTF:           "yes",
+		Runtime:       "containerd",
+		Socket:        "run_containerd_containerd.sock",
+		Arch:          "amd64",
+		KernelVersion: "6.1.0",
+		Mode:          operatorv1.NodeModeEnforce,
+		Matched:       true,
+	}, enforce)
+
+	assert.Equal(t, operatorv1.NodeModeAudit, audit.Mode)
+	assert.True(t, audit.Matched)
+	assert.Len(t, audit.Issues, 2)
+
+	assert.Equal(t, operatorv1.NodeModeNone, undetected.Mode)
+	assert.False(t, undetected.Matched)
+
+	assert.Equal(t, operatorv1.NodeModeNone, missing.Mode)
+	assert.False(t, missing.Matched)
+	assert.Equal(t, []string{"no daemonset would select the node, missing labels kubearmor.io/seccomp"}, missing.Issues)
+
+	summary := PreflightSummary(reports)
+	assert.Equal(t, 1, summary.EnforceNodes)
+	assert.Equal(t, 1, summary.AuditNodes)
+	assert.Equal(t, 2, summary.UnmatchedNodes)
+	// enforcing nodes are not listed
+	assert.Len(t, summary.Nodes, 3)
+}
@@ -0,0 +1,478 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metaerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/helm"
+)
+
+// rolloutPollInterval is how often a rollout in progress is checked
+const rolloutPollInterval = 10 * time.Second
+
+// rolloutReleases is the part of the helm controller rollouts use
+type rolloutReleases interface {
+	Release() (*release.Release, error)
+	RollbackRelease(ctx context.Context) (*release.Release, error)
+	HaltUpgrades(generation int64)
+}
+
+// RolloutReconciler performs canary rollouts of KubeArmor release revisions.
+// The release renders the KubeArmor daemonsets with the OnDelete update
+// strategy, the reconciler then restarts the pods of one daemonset, i.e. one
+// node configuration group, at a time and only moves on once they are ready and
+// pass the health gates of the rollout strategy
+type RolloutReconciler struct {
+	client.Client
+	Clientset kubernetes.Interface
+	Releases  rolloutReleases
+	// Namespace KubeArmor is deployed in
+	Namespace string
+	Recorder  record.EventRecorder
+	// HTTPClient queries Prometheus for the alert rate gate
+	HTTPClient *http.Client
+}
+
+//+kubebuilder:rbac:groups="",resources=pods,verbs=list
+//+kubebuilder:rbac:groups="",namespace=system,resources=pods,verbs=delete
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch
+
+// Reconcile advances the canary rollout of the latest release revision
+func (r *RolloutReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	config := &operatorv1.KubeArmorConfig{}
+	if err := r.Get(ctx, req.NamespacedName, config); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	strategy := config.Spec.Rollout
+	if strategy == nil || strategy.Type != operatorv1.CanaryRollout {
+		return ctrl.Result{}, nil
+	}
+	if active, err := isActiveKubeArmorConfig(ctx, r.Client, config); err != nil || !active {
+		// the strategy of ignored instances does not apply to the release
+		return ctrl.Result{}, err
+	}
+	if helm.Paused(config) {
+		// restarting pods is a change as well
+		return ctrl.Result{}, nil
+	}
+	rel, err := r.Releases.Release()
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	status := config.Status.Rollout.DeepCopy()
+	if status == nil || status.Revision != rel.Version {
+		logger.Info("starting canary rollout", "revision", rel.Version)
+		status = &operatorv1.RolloutStatus{
+			Revision:           rel.Version,
+			ObservedGeneration: config.Generation,
+			Phase:              operatorv1.RolloutProgressing,
+		}
+	}
+
+	outdated, err := r.outdatedDaemonSets(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var result ctrl.Result
+	switch status.Phase {
+	case operatorv1.RolloutProgressing:
+		result, err = r.progress(ctx, config, strategy, status, outdated)
+	case operatorv1.RolloutRollingBack:
+		result, err = r.rollBack(ctx, config, status, outdated)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.updateStatus(ctx, config, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// progress restarts the next outdated daemonset once the current one passed
+// the health gates, and handles failed gates according to the strategy
+func (r *RolloutReconciler) progress(ctx context.Context, config *operatorv1.KubeArmorConfig, strategy *operatorv1.RolloutStrategy, status *operatorv1.RolloutStatus, outdated []appsv1.DaemonSet) (ctrl.Result, error) {
+	requeue := ctrl.Result{RequeueAfter: rolloutPollInterval}
+	if status.CurrentDaemonSet == "" {
+		for _, ds := range outdated {
+			if contains(status.UpdatedDaemonSets, ds.Name) {
+				continue
+			}
+			if err := r.restartPods(ctx, &ds); err != nil {
+				return ctrl.Result{}, err
+			}
+			now := metav1.Now()
+			status.CurrentDaemonSet, status.StartTime, status.ReadyTime = ds.Name, &now, nil
+			status.Message = fmt.Sprintf("updating daemonset %s", ds.Name)
+			r.Recorder.Eventf(config, corev1.EventTypeNormal, defaults.RolloutStepReason,
+				"updating daemonset %s to release revision %d", ds.Name, status.Revision)
+			return requeue, nil
+		}
+		status.Phase, status.Message = operatorv1.RolloutCompleted, ""
+		r.Recorder.Eventf(config, corev1.EventTypeNormal, defaults.RolloutCompletedReason,
+			"release revision %d rolled out to %d daemonsets", status.Revision, len(status.UpdatedDaemonSets))
+		return ctrl.Result{}, nil
+	}
+
+	ds := &appsv1.DaemonSet{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: status.CurrentDaemonSet}, ds)
+	if metaerrors.IsNotFound(err) {
+		// the node configuration is gone, nothing left to check
+		r.finishStep(status)
+		return requeue, nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	failure, err := r.podFailure(ctx, ds, strategy, status.StartTime.Time)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if failure == "" && !daemonSetReady(ds) {
+		deadline := status.StartTime.Add(time.Duration(strategy.ProgressDeadlineSeconds) * time.Second)
+		if time.Now().Before(deadline) {
+			return requeue, nil
+		}
+		failure = fmt.Sprintf("pods of daemonset %s not ready within %ds", ds.Name, strategy.ProgressDeadlineSeconds)
+	}
+	if failure == "" {
+		if status.ReadyTime == nil {
+			now := metav1.Now()
+			status.ReadyTime = &now
+		}
+		stable := status.ReadyTime.Add(time.Duration(strategy.StabilizationSeconds) * time.Second)
+		if wait := time.Until(stable); wait > 0 {
+			return ctrl.Result{RequeueAfter: min(wait, rolloutPollInterval)}, nil
+		}
+		if strategy.AlertRate != nil {
+			if failure, err = r.alertRateFailure(ctx, strategy.AlertRate, ds.Name); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+	if failure != "" {
+		return r.fail(ctx, config, strategy, status, failure)
+	}
+
+	r.finishStep(status)
+	r.Recorder.Eventf(config, corev1.EventTypeNormal, defaults.RolloutStepReason,
+		"daemonset %s updated to release revision %d", ds.Name, status.Revision)
+	return requeue, nil
+}
+
+// finishStep marks the current daemonset as updated
+func (r *RolloutReconciler) finishStep(status *operatorv1.RolloutStatus) {
+	status.UpdatedDaemonSets = append(status.UpdatedDaemonSets, status.CurrentDaemonSet)
+	status.CurrentDaemonSet, status.StartTime, status.ReadyTime, status.Message = "", nil, nil, ""
+}
+
+// fail pauses the rollout or rolls the release back
+func (r *RolloutReconciler) fail(ctx context.Context, config *operatorv1.KubeArmorConfig, strategy *operatorv1.RolloutStrategy, status *operatorv1.RolloutStatus, failure string) (ctrl.Result, error) {
+	log.FromContext(ctx).Info("canary rollout failed", "revision", status.Revision, "reason", failure)
+	// upgrades would otherwise reapply the failed spec, the helm controller
+	// keeps halting them after restarts as long as the status reports it
+	r.Releases.HaltUpgrades(status.ObservedGeneration)
+	if strategy.OnFailure != operatorv1.RollbackOnFailure {
+		status.Phase, status.Message = operatorv1.RolloutPaused, failure
+		r.Recorder.Eventf(config, corev1.EventTypeWarning, defaults.RolloutFailedReason,
+			"rollout of release revision %d paused: %s", status.Revision, failure)
+		return ctrl.Result{}, nil
+	}
+	r.Recorder.Eventf(config, corev1.EventTypeWarning, defaults.RolloutFailedReason,
+		"rolling back release revision %d: %s", status.Revision, failure)
+	rel, err := r.Releases.RollbackRelease(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	*status = operatorv1.RolloutStatus{
+		Revision:           rel.Version,
+		ObservedGeneration: status.ObservedGeneration,
+		Phase:              operatorv1.RolloutRollingBack,
+		Message:            failure,
+	}
+	return ctrl.Result{RequeueAfter: rolloutPollInterval}, nil
+}
+
+// rollBack restarts the daemonsets updated before the rollback, all at once
+// as they are restored to the revision that was running before
+func (r *RolloutReconciler) rollBack(ctx context.Context, config *operatorv1.KubeArmorConfig, status *operatorv1.RolloutStatus, outdated []appsv1.DaemonSet) (ctrl.Result, error) {
+	for _, ds := range outdated {
+		if contains(status.UpdatedDaemonSets, ds.Name) {
+			continue
+		}
+		if err := r.restartPods(ctx, &ds); err != nil {
+			return ctrl.Result{}, err
+		}
+		status.UpdatedDaemonSets = append(status.UpdatedDaemonSets, ds.Name)
+	}
+	status.Phase = operatorv1.RolloutRolledBack
+	r.Recorder.Eventf(config, corev1.EventTypeNormal, defaults.RolloutCompletedReason,
+		"release rolled back to revision %d, restarted %d daemonsets", status.Revision, len(status.UpdatedDaemonSets))
+	return ctrl.Result{}, nil
+}
+
+// outdatedDaemonSets returns the KubeArmor daemonsets with pods not running
+// their current template, sorted by name
+func (r *RolloutReconciler) outdatedDaemonSets(ctx context.Context) ([]appsv1.DaemonSet, error) {
+	selector, err := metav1.ParseToLabelSelector(defaults.KubeArmorDaemonSetSelector)
+	if err != nil {
+		return nil, err
+	}
+	matchLabels := client.MatchingLabels(selector.MatchLabels)
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := r.List(ctx, daemonSets, client.InNamespace(r.Namespace), matchLabels); err != nil {
+		return nil, err
+	}
+	outdated := []appsv1.DaemonSet{}
+	for _, ds := range daemonSets.Items {
+		if ds.Status.ObservedGeneration < ds.Generation ||
+			ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled {
+			outdated = append(outdated, ds)
+		}
+	}
+	sort.Slice(outdated, func(i, j int) bool { return outdated[i].Name < outdated[j].Name })
+	return outdated, nil
+}
+
+// daemonSetPods returns the pods controlled by the daemonset
+func (r *RolloutReconciler) daemonSetPods(ctx context.Context, ds *appsv1.DaemonSet) ([]corev1.Pod, error) {
+	selector, err := metav1.LabelSelectorAsSelector(ds.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := r.Clientset.CoreV1().Pods(ds.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	owned := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		if owner := metav1.GetControllerOf(&pod); owner != nil && owner.UID == ds.UID {
+			owned = append(owned, pod)
+		}
+	}
+	return owned, nil
+}
+
+// restartPods deletes the pods of an OnDelete daemonset so that they are
+// recreated from its current template
+func (r *RolloutReconciler) restartPods(ctx context.Context, ds *appsv1.DaemonSet) error {
+	pods, err := r.daemonSetPods(ctx, ds)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		err := r.Clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		if err != nil && !metaerrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// podFailure returns why the pods of the daemonset created since the step
+// started fail the health gates, empty if they pass
+func (r *RolloutReconciler) podFailure(ctx context.Context, ds *appsv1.DaemonSet, strategy *operatorv1.RolloutStrategy, since time.Time) (string, error) {
+	pods, err := r.daemonSetPods(ctx, ds)
+	if err != nil {
+		return "", err
+	}
+	for _, pod := range pods {
+		if pod.CreationTimestamp.Time.Before(since) {
+			continue
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Waiting != nil && cs.State.Waiting.Reason == "CrashLoopBackOff" {
+				return fmt.Sprintf("container %s of pod %s is crash looping", cs.Name, pod.Name), nil
+			}
+			if cs.RestartCount > strategy.MaxRestarts {
+				return fmt.Sprintf("container %s of pod %s restarted %d times", cs.Name, pod.Name, cs.RestartCount), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// daemonSetReady reports whether all pods of the daemonset run its current
+// template and are available
+func daemonSetReady(ds *appsv1.DaemonSet) bool {
+	return ds.Status.ObservedGeneration >= ds.Generation &&
+		ds.Status.UpdatedNumberScheduled >= ds.Status.DesiredNumberScheduled &&
+		ds.Status.NumberAvailable >= ds.Status.DesiredNumberScheduled
+}
+
+// alertRateFailure evaluates the alert rate gate for the daemonset
+func (r *RolloutReconciler) alertRateFailure(ctx context.Context, gate *operatorv1.AlertRateGate, daemonSet string) (string, error) {
+	bound, err := strconv.ParseFloat(gate.Max, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid alert rate bound %q: %s", gate.Max, err.Error())
+	}
+	query := strings.ReplaceAll(gate.Query, "$daemonset", daemonSet)
+	value, err := r.queryPrometheus(ctx, gate.PrometheusURL, query)
+	if err != nil {
+		return "", fmt.Errorf("alert rate query failed: %s", err.Error())
+	}
+	if value > bound {
+		return fmt.Sprintf("alert rate %g of daemonset %s exceeds %s", value, daemonSet, gate.Max), nil
+	}
+	return "", nil
+}
+
+// prometheusResponse is the response of the Prometheus instant query API
+type prometheusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// queryPrometheus evaluates an instant query and returns the highest value of
+// the result, 0 if the result is empty
+func (r *RolloutReconciler) queryPrometheus(ctx context.Context, baseURL, query string) (float64, error) {
+	httpClient := r.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	endpoint := strings.TrimSuffix(baseURL, "/") + "/api/v1/query?" + url.Values{"query": {query}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body := prometheusResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("error decoding response: %s", err.Error())
+	}
+	if body.Status != "success" {
+		return 0, fmt.Errorf("query failed: %s", body.Error)
+	}
+
+	var samples [][]interface{}
+	switch body.Data.ResultType {
+	case "scalar":
+		var sample []interface{}
+		if err := json.Unmarshal(body.Data.Result, &sample); err != nil {
+			return 0, err
+		}
+		samples = append(samples, sample)
+	case "vector":
+		var vector []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(body.Data.Result, &vector); err != nil {
+			return 0, err
+		}
+		for _, v := range vector {
+			samples = append(samples, v.Value)
+		}
+	default:
+		return 0, fmt.Errorf("unsupported result type %s", body.Data.ResultType)
+	}
+
+	value := 0.0
+	for _, sample := range samples {
+		if len(sample) != 2 {
+			return 0, fmt.Errorf("malformed sample %v", sample)
+		}
+		s, _ := sample[1].(string)
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("malformed sample value %v", sample[1])
+		}
+		value = max(value, v)
+	}
+	return value, nil
+}
+
+// updateStatus patches the rollout status if it changed
+func (r *RolloutReconciler) updateStatus(ctx context.Context, config *operatorv1.KubeArmorConfig, status *operatorv1.RolloutStatus) error {
+	if equality.Semantic.DeepEqual(config.Status.Rollout, status) {
+		return nil
+	}
+	patch := client.MergeFrom(config.DeepCopy())
+	config.Status.Rollout = status
+	return r.Status().Patch(ctx, config, patch)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// kubeArmorConfigsForDaemonSet maps KubeArmor daemonset changes to all
+// kubearmorconfig instances
+func (r *RolloutReconciler) kubeArmorConfigsForDaemonSet(ctx context.Context, _ client.Object) []reconcile.Request {
+	configs := &operatorv1.KubeArmorConfigList{}
+	if err := r.List(ctx, configs); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, config := range configs.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: config.Namespace, Name: config.Name},
+		})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *RolloutReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	selector, err := metav1.ParseToLabelSelector(defaults.KubeArmorDaemonSetSelector)
+	if err != nil {
+		return err
+	}
+	daemonSetSelector, err := predicate.LabelSelectorPredicate(*selector)
+	if err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("rollout").
+		For(&operatorv1.KubeArmorConfig{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Watches(&appsv1.DaemonSet{}, handler.EnqueueRequestsFromMapFunc(r.kubeArmorConfigsForDaemonSet),
+			builder.WithPredicates(daemonSetSelector)).
+		Complete(r)
+}
@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/release"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metaerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/helm"
+)
+
+type testReleases struct {
+	version   int
+	rollbacks int
+	halted    int64
+}
+
+func (t *testReleases) Release() (*release.Release, error) {
+	return &release.Release{Name: "kubearmor", Version: t.version}, nil
+}
+
+func (t *testReleases) RollbackRelease(context.Context) (*release.Release, error) {
+	t.version++
+	t.rollbacks++
+	return t.Release()
+}
+
+func (t *testReleases) HaltUpgrades(generation int64) {
+	t.halted = generation
+}
+
+func testDaemonSet(name string, updated int32) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  "kubearmor",
+			UID:        types.UID(name),
+			Generation: 1,
+			Labels:     map[string]string{"kubearmor-app": "kubearmor"},
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubearmor-app": "kubearmor"}},
+		},
+		Status: appsv1.DaemonSetStatus{
+			ObservedGeneration:     1,
+			DesiredNumberScheduled: 1,
+			UpdatedNumberScheduled: updated,
+			NumberAvailable:        1,
+		},
+	}
+}
+
+func testDaemonSetPod(name, daemonSet string, created time.Time, waiting string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "kubearmor",
+			Labels:            map[string]string{"kubearmor-app": "kubearmor"},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "kubearmor"}}},
+	}
+	controller := true
+	pod.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "apps/v1",
+		Kind:       "DaemonSet",
+		Name:       daemonSet,
+		UID:        types.UID(daemonSet),
+		Controller: &controller,
+	}}
+	if waiting != "" {
+		pod.Status.ContainerStatuses[0].State.Waiting = &corev1.ContainerStateWaiting{Reason: waiting}
+	}
+	return pod
+}
+
+// testClient returns a fake client with the operator scheme holding the objects
+func testClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	assert.NoError(t, operatorv1.AddToScheme(scheme))
+	return ctrlfake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+		WithStatusSubresource(&operatorv1.KubeArmorConfig{}).Build()
+}
+
+func testRolloutReconciler(t *testing.T, onFailure operatorv1.RolloutFailurePolicy, releases *testReleases, objs ...client.Object) *RolloutReconciler {
+	config := &operatorv1.KubeArmorConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubearmorconfig-default", Namespace: "kubearmor", Generation: 1},
+		Spec: operatorv1.KubeArmorConfigSpec{Rollout: &operatorv1.RolloutStrategy{
+			Type:                    operatorv1.CanaryRollout,
+			ProgressDeadlineSeconds: 600,
+			OnFailure:               onFailure,
+		}},
+	}
+	return &RolloutReconciler{
+		Client:    testClient(t, append(objs, config)...),
+		Clientset: fake.NewSimpleClientset(),
+		Releases:  releases,
+		Namespace: "kubearmor",
+		Recorder:  record.NewFakeRecorder(100),
+	}
+}
+
+func reconcileRollout(t *testing.T, r *RolloutReconciler) *operatorv1.RolloutStatus {
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "kubearmor", Name: "kubearmorconfig-default"}}
+	_, err := r.Reconcile(context.Background(), req)
+	assert.NoError(t, err)
+	config := &operatorv1.KubeArmorConfig{}
+	assert.NoError(t, r.Get(context.Background(), req.NamespacedName, config))
+	return config.Status.Rollout
+}
+
+func setDaemonSetUpdated(t *testing.T, r *RolloutReconciler, name string, updated int32) {
+	ds := &appsv1.DaemonSet{}
+	assert.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "kubearmor", Name: name}, ds))
+	ds.Status.UpdatedNumberScheduled = updated
+	assert.NoError(t, r.Status().Update(context.Background(), ds))
+}
+
+func TestCanaryRollout(t *testing.T) {
+	ctx := context.Background()
+	releases := &testReleases{version: 2}
+	r := testRolloutReconciler(t, operatorv1.PauseOnFailure, releases,
+		testDaemonSet("kubearmor-bpf-containerd-98c2c", 0),
+		testDaemonSet("kubearmor-apparmor-cri-o-6aa4e", 0),
+	)
+	pods := r.Clientset.CoreV1().Pods("kubearmor")
+	_, err := pods.Create(ctx, testDaemonSetPod("apparmor-old", "kubearmor-apparmor-cri-o-6aa4e", time.Now().Add(-time.Hour), ""), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	_, err = pods.Create(ctx, testDaemonSetPod("bpf-old", "kubearmor-bpf-containerd-98c2c", time.Now().Add(-time.Hour), ""), metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	// daemonsets are updated in name order, one at a time
+	status := reconcileRollout(t, r)
+	assert.Equal(t, operatorv1.RolloutProgressing, status.Phase)
+	assert.Equal(t, 2, status.Revision)
+	assert.Equal(t, "kubearmor-apparmor-cri-o-6aa4e", status.CurrentDaemonSet)
+	_, err = pods.Get(ctx, "apparmor-old", metav1.GetOptions{})
+	assert.True(t, metaerrors.IsNotFound(err))
+	_, err = pods.Get(ctx, "bpf-old", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// waits for the pods to become ready
+	status = reconcileRollout(t, r)
+	assert.Equal(t, "kubearmor-apparmor-cri-o-6aa4e", status.CurrentDaemonSet)
+	assert.Nil(t, status.ReadyTime)
+
+	setDaemonSetUpdated(t, r, "kubearmor-apparmor-cri-o-6aa4e", 1)
+	_, err = pods.Create(ctx, testDaemonSetPod("apparmor-new", "kubearmor-apparmor-cri-o-6aa4e", time.Now(), ""), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	status = reconcileRollout(t, r)
+	assert.Equal(t, []string{"kubearmor-apparmor-cri-o-6aa4e"}, status.UpdatedDaemonSets)
+	assert.Empty(t, status.CurrentDaemonSet)
+
+	status = reconcileRollout(t, r)
+	assert.Equal(t, "kubearmor-bpf-containerd-98c2c", status.CurrentDaemonSet)
+
+	// crash looping pods pause the rollout
+	_, err = pods.Create(ctx, testDaemonSetPod("bpf-new", "kubearmor-bpf-containerd-98c2c", time.Now(), "CrashLoopBackOff"), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	status = reconcileRollout(t, r)
+	assert.Equal(t, operatorv1.RolloutPaused, status.Phase)
+	assert.Contains(t, status.Message, "crash looping")
+	assert.Zero(t, releases.rollbacks)
+	assert.Equal(t, int64(1), releases.halted)
+
+	// a new revision starts a new rollout
+	assert.NoError(t, pods.Delete(ctx, "bpf-new", metav1.DeleteOptions{}))
+	setDaemonSetUpdated(t, r, "kubearmor-bpf-containerd-98c2c", 1)
+	releases.version = 3
+	status = reconcileRollout(t, r)
+	assert.Equal(t, operatorv1.RolloutCompleted, status.Phase)
+	assert.Equal(t, 3, status.Revision)
+}
+
+func TestCanaryRollback(t *testing.T) {
+	ctx := context.Background()
+	releases := &testReleases{version: 2}
+	r := testRolloutReconciler(t, operatorv1.RollbackOnFailure, releases,
+		testDaemonSet("kubearmor-bpf-containerd-98c2c", 0),
+	)
+	pods := r.Clientset.CoreV1().Pods("kubearmor")
+
+	status := reconcileRollout(t, r)
+	assert.Equal(t, "kubearmor-bpf-containerd-98c2c", status.CurrentDaemonSet)
+	_, err := pods.Create(ctx, testDaemonSetPod("bpf-new", "kubearmor-bpf-containerd-98c2c", time.Now(), "CrashLoopBackOff"), metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	status = reconcileRollout(t, r)
+	assert.Equal(t, 1, releases.rollbacks)
+	assert.Equal(t, int64(1), releases.halted)
+	assert.Equal(t, operatorv1.RolloutRollingBack, status.Phase)
+	assert.Equal(t, 3, status.Revision)
+
+	// the updated pods are restarted with the previous revision
+	status = reconcileRollout(t, r)
+	assert.Equal(t, operatorv1.RolloutRolledBack, status.Phase)
+	_, err = pods.Get(ctx, "bpf-new", metav1.GetOptions{})
+	assert.True(t, metaerrors.IsNotFound(err))
+	assert.Equal(t, 1, releases.rollbacks)
+}
+
+func TestRolloutFailureHaltsUpgrades(t *testing.T) {
+	ctx := context.Background()
+	config := &operatorv1.KubeArmorConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubearmorconfig-default", Namespace: "kubearmor", Generation: 1},
+	}
+
+	// the rollout of the spec fails while the operator runs
+	releases := &helm.Controller{}
+	releases.UpdateHelmValuesFromKubeArmorConfig(config)
+	releases.HaltUpgrades(1)
+	_, err := releases.UpgradeRelease(ctx)
+	assert.IsType(t, &helm.DeferredError{}, err)
+
+	// or the operator restarts after it failed
+	config.Status.Rollout = &operatorv1.RolloutStatus{ObservedGeneration: 1, Phase: operatorv1.RolloutRolledBack}
+	releases = &helm.Controller{}
+	releases.UpdateHelmValuesFromKubeArmorConfig(config)
+	_, err = releases.UpgradeRelease(ctx)
+	assert.IsType(t, &helm.DeferredError{}, err)
+
+	// a spec change is upgraded again
+	config.Generation = 2
+	releases.UpdateHelmValuesFromKubeArmorConfig(config)
+	_, err = releases.UpgradeRelease(ctx)
+	assert.ErrorIs(t, err, helm.ErrValuesIncomplete)
+}
+
+func TestAlertRateGate(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query = req.URL.Query().Get("query")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"node":"a"},"value":[1700000000,"0.5"]},
+			{"metric":{"node":"b"},"value":[1700000000,"2.5"]}]}}`))
+	}))
+	defer server.Close()
+
+	r := &RolloutReconciler{}
+	gate := &operatorv1.AlertRateGate{
+		PrometheusURL: server.URL,
+		Query:         `sum(rate(kubearmor_alerts_total{daemonset="$daemonset"}[5m]))`,
+		Max:           "3",
+	}
+	failure, err := r.alertRateFailure(context.Background(), gate, "kubearmor-bpf-containerd-98c2c")
+	assert.NoError(t, err)
+	assert.Empty(t, failure)
+	assert.Equal(t, `sum(rate(kubearmor_alerts_total{daemonset="kubearmor-bpf-containerd-98c2c"}[5m]))`, query)
+
+	gate.Max = "1"
+	failure, err = r.alertRateFailure(context.Background(), gate, "kubearmor-bpf-containerd-98c2c")
+	assert.NoError(t, err)
+	assert.Contains(t, failure, "alert rate 2.5")
+}
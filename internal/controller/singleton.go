@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+)
+
+// activeKubeArmorConfig returns the kubearmorconfig the release is made from,
+// nil if there is none. A single release is deployed, so only the oldest
+// kubearmorconfig is active, ties are broken by namespace and name
+func activeKubeArmorConfig(ctx context.Context, r client.Reader) (*operatorv1.KubeArmorConfig, error) {
+	configs := &operatorv1.KubeArmorConfigList{}
+	if err := r.List(ctx, configs); err != nil {
+		return nil, fmt.Errorf("error listing kubearmorconfigs: %s", err.Error())
+	}
+	candidates := []operatorv1.KubeArmorConfig{}
+	for _, config := range configs.Items {
+		if config.DeletionTimestamp.IsZero() {
+			candidates = append(candidates, config)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return &candidates[0], nil
+}
+
+// isActiveKubeArmorConfig reports whether config is the active kubearmorconfig
+func isActiveKubeArmorConfig(ctx context.Context, r client.Reader, config *operatorv1.KubeArmorConfig) (bool, error) {
+	active, err := activeKubeArmorConfig(ctx, r)
+	if err != nil || active == nil {
+		return false, err
+	}
+	return active.Namespace == config.Namespace && active.Name == config.Name, nil
+}
+
+// allKubeArmorConfigs maps an event to all kubearmorconfig instances, so that
+// another instance becomes active when the active one is deleted
+func allKubeArmorConfigs(r client.Reader) func(context.Context, client.Object) []reconcile.Request {
+	return func(ctx context.Context, _ client.Object) []reconcile.Request {
+		configs := &operatorv1.KubeArmorConfigList{}
+		if err := r.List(ctx, configs); err != nil {
+			return nil
+		}
+		requests := []reconcile.Request{}
+		for _, config := range configs.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: config.Namespace, Name: config.Name},
+			})
+		}
+		return requests
+	}
+}
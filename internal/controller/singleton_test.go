@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/helm"
+)
+
+func testKubeArmorConfig(namespace, name string, created time.Time) *operatorv1.KubeArmorConfig {
+	return &operatorv1.KubeArmorConfig{ObjectMeta: metav1.ObjectMeta{
+		Namespace:         namespace,
+		Name:              name,
+		CreationTimestamp: metav1.NewTime(created),
+	}}
+}
+
+func TestActiveKubeArmorConfig(t *testing.T) {
+	ctx := context.Background()
+	created := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	c := testClient(t)
+
+	active, err := activeKubeArmorConfig(ctx, c)
+	assert.NoError(t, err)
+	assert.Nil(t, active)
+
+	for _, config := range []*operatorv1.KubeArmorConfig{
+		testKubeArmorConfig("kubearmor", "kubearmorconfig-new", created.Add(time.Hour)),
+		testKubeArmorConfig("kubearmor", "kubearmorconfig-b", created),
+		testKubeArmorConfig("kubearmor", "kubearmorconfig-a", created),
+	} {
+		assert.NoError(t, c.Create(ctx, config))
+	}
+	// ties are broken by name
+	active, err = activeKubeArmorConfig(ctx, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "kubearmorconfig-a", active.Name)
+
+	r := &KubeArmorConfigReconciler{Client: c, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(10)}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "kubearmor", Name: "kubearmorconfig-new"}}
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	config := &operatorv1.KubeArmorConfig{}
+	assert.NoError(t, c.Get(ctx, req.NamespacedName, config))
+	assert.Equal(t, operatorv1.ConfigIgnored, config.Status.Phase)
+	assert.Contains(t, config.Status.Message, "kubearmor/kubearmorconfig-a is active")
+	assert.Len(t, r.Recorder.(*record.FakeRecorder).Events, 1)
+
+	// ignored instances are reported once
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+	assert.Len(t, r.Recorder.(*record.FakeRecorder).Events, 1)
+
+	// the next oldest instance becomes active when the active one is deleted
+	assert.NoError(t, c.Delete(ctx, testKubeArmorConfig("kubearmor", "kubearmorconfig-a", created)))
+	active, err = activeKubeArmorConfig(ctx, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "kubearmorconfig-b", active.Name)
+	ok, err := isActiveKubeArmorConfig(ctx, c, config)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestInvalidKubeArmorArgs(t *testing.T) {
+	ctx := context.Background()
+	config := testKubeArmorConfig("kubearmor", "kubearmorconfig-default", time.Now())
+	config.Spec.NodeProfiles = []operatorv1.NodeProfile{{Name: "gpu", Args: []string{"-tlsEnabled=false"}}}
+	c := testClient(t, config)
+	r := &KubeArmorConfigReconciler{helmController: &helm.Controller{}, Client: c, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(10)}
+
+	// reported in the status of the active instance until the spec is fixed
+	reconcileObjects(t, r, config)
+	assert.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(config), config))
+	assert.Equal(t, operatorv1.ConfigActive, config.Status.Phase)
+	assert.Contains(t, config.Status.Message, "invalid kubearmor arguments: argument -tlsEnabled=false of nodeProfiles[gpu].args is set by the chart")
+
+	config.Spec.NodeProfiles[0].Args = []string{"-enableKubeArmorHostPolicy"}
+	assert.NoError(t, c.Update(ctx, config))
+	reconcileObjects(t, r, config)
+	assert.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(config), config))
+	assert.Empty(t, config.Status.Message)
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metaerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/helm"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/supportbundle"
+)
+
+const (
+	// supportBundleKey is the secret key the bundle is stored under
+	supportBundleKey = "bundle.tar.gz"
+	// maxSupportBundleSize keeps the secret below the 1MiB object size limit
+	maxSupportBundleSize = 900 * 1024
+	// supportBundleLogLines is lower than the cli default to fit in a secret
+	supportBundleLogLines = 200
+)
+
+// SupportBundleReconciler collects a support bundle when the support bundle
+// annotation of a kubearmorconfig instance is set to a new value, the bundle
+// is stored in a secret of the operator namespace
+type SupportBundleReconciler struct {
+	client.Client
+	Clientset      kubernetes.Interface
+	HelmController *helm.Controller
+	// Namespace KubeArmor is deployed in
+	Namespace string
+	Recorder  record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups="",resources=pods,verbs=list
+//+kubebuilder:rbac:groups="",resources=pods/log,verbs=get
+//+kubebuilder:rbac:groups="",resources=events,verbs=list
+
+// Reconcile collects a support bundle if the requested one has not been collected yet
+func (r *SupportBundleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	config := &operatorv1.KubeArmorConfig{}
+	if err := r.Get(ctx, req.NamespacedName, config); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	request := config.Annotations[defaults.SupportBundleAnnotation]
+	if request == "" {
+		return ctrl.Result{}, nil
+	}
+
+	secrets := r.Clientset.CoreV1().Secrets(r.Namespace)
+	secret, err := secrets.Get(ctx, defaults.SupportBundleSecretName, metav1.GetOptions{})
+	exists := err == nil
+	if err != nil && !metaerrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	if exists && secret.Annotations[defaults.SupportBundleAnnotation] == request {
+		return ctrl.Result{}, nil
+	}
+
+	collector := &supportbundle.Collector{
+		Clientset: r.Clientset,
+		Client:    r.Client,
+		Helm:      r.HelmController,
+		Namespace: r.Namespace,
+		LogLines:  supportBundleLogLines,
+	}
+	data, err := collector.Bytes(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(data) > maxSupportBundleSize {
+		err := fmt.Errorf("support bundle of %d bytes exceeds the secret size limit, collect it with the kubearmor-operator cli", len(data))
+		r.Recorder.Event(config, corev1.EventTypeWarning, defaults.SupportBundleReason, err.Error())
+		// retrying would collect a bundle of the same size
+		logger.Error(err, "unable to store support bundle")
+		return ctrl.Result{}, nil
+	}
+
+	if !exists {
+		secret = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:      defaults.SupportBundleSecretName,
+			Namespace: r.Namespace,
+		}}
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[defaults.SupportBundleAnnotation] = request
+	secret.Type = corev1.SecretTypeOpaque
+	secret.Data = map[string][]byte{supportBundleKey: data}
+	// owner references can't cross namespaces
+	if config.Namespace == r.Namespace {
+		if err := ctrl.SetControllerReference(config, secret, r.Scheme()); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !exists {
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	} else {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Eventf(config, corev1.EventTypeNormal, defaults.SupportBundleReason,
+		"support bundle %q stored in secret %s/%s", request, r.Namespace, defaults.SupportBundleSecretName)
+	logger.Info("collected support bundle", "request", request, "size", len(data))
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager, bundles are only
+// collected when annotations change
+func (r *SupportBundleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("supportbundle").
+		For(&operatorv1.KubeArmorConfig{}, builder.WithPredicates(predicate.AnnotationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+)
+
+func TestResolveUserValues(t *testing.T) {
+	config := &operatorv1.KubeArmorConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubearmorconfig-default", Namespace: "kubearmor"},
+		Spec: operatorv1.KubeArmorConfigSpec{
+			ValuesFrom: []operatorv1.ValuesReference{
+				{Kind: "ConfigMap", Name: "volumes"},
+				{Kind: "Secret", Name: "volumes", Key: "extra.yaml"},
+			},
+			Values:          &apiextensionsv1.JSON{Raw: []byte(`{"kubearmor":{"volumes":[{"name":"audit","path":"/var/log/audit"}]}}`)},
+			ValuesListMerge: []operatorv1.ValuesListMerge{{Path: "kubearmor.volumes"}},
+		},
+	}
+	c := testClient(t,
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "volumes", Namespace: "kubearmor"}, Data: map[string]string{
+			"values.yaml": "kubearmor:\n  volumes:\n  - name: audit\n    path: /audit\n  - name: policies\n    path: /policies\n",
+		}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "volumes", Namespace: "kubearmor"}, Data: map[string][]byte{
+			"extra.yaml": []byte("kubearmor:\n  volumes:\n  - name: certs\n    path: /certs\n"),
+		}},
+	)
+
+	values, err := ResolveUserValues(context.Background(), c, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "audit", "path": "/var/log/audit"},
+		map[string]interface{}{"name": "policies", "path": "/policies"},
+		map[string]interface{}{"name": "certs", "path": "/certs"},
+	}, values["kubearmor"].(map[string]interface{})["volumes"])
+
+	// other lists are replaced
+	config.Spec.ValuesListMerge = nil
+	values, err = ResolveUserValues(context.Background(), c, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "audit", "path": "/var/log/audit"},
+	}, values["kubearmor"].(map[string]interface{})["volumes"])
+}
@@ -0,0 +1,329 @@
+package helm
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	semver "github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+)
+
+// chartManagedFlags are rendered by the chart into the KubeArmor container
+var chartManagedFlags = map[string]bool{
+	"gRPC":            true,
+	"tlsEnabled":      true,
+	"tlsCertPath":     true,
+	"tlsCertProvider": true,
+}
+
+// daemonFlags are the KubeArmor daemon flags by the version introducing them,
+// flags of v1.0.0 are available in all KubeArmor versions the operator deploys
+var daemonFlags = map[string][]string{
+	"v1.0.0": {
+		"cluster", "host", "gRPC", "logPath", "seLinuxProfileDir", "criSocket",
+		"tlsEnabled", "tlsCertPath", "tlsCertProvider",
+		"visibility", "defaultFilePosture", "defaultNetworkPosture", "defaultCapabilitiesPosture",
+		"hostVisibility", "hostDefaultFilePosture", "hostDefaultNetworkPosture", "hostDefaultCapabilitiesPosture",
+		"enableKubeArmorPolicy", "enableKubeArmorHostPolicy", "enableKubeArmorVm", "enableKubeArmorStateAgent",
+		"k8s", "lsm", "bpfFsPath", "procfsMount", "enforcerAlerts", "initTimeout", "coverageTest", "debug",
+		"alertThrottling", "maxAlertPerSec", "throttleSec", "annotateResources", "annotateExisting",
+	},
+	"v1.1.0": {"defaultPostureLogs"},
+	"v1.3.0": {"untrackedNs"},
+}
+
+// flagVersions maps each daemon flag to the version introducing it
+var flagVersions = func() map[string]*semver.Version {
+	versions := map[string]*semver.Version{}
+	for version, flags := range daemonFlags {
+		for _, flag := range flags {
+			versions[flag] = semver.MustParse(version)
+		}
+	}
+	return versions
+}()
+
+var extraArgPattern = regexp.MustCompile(`^--?[A-Za-z][A-Za-z0-9.]*(=.*)?$`)
+
+// daemonFlag is a KubeArmor daemon flag generated from a kubearmorArgs or
+// hostSecurity field
+type daemonFlag struct {
+	name, value, field string
+}
+
+func typedFlags(spec operatorv1.KubeArmorConfigSpec) []daemonFlag {
+	args := spec.KubeArmorArgs
+	flags := []daemonFlag{}
+	if len(args.LSMOrder) > 0 {
+		lsms := make([]string, 0, len(args.LSMOrder))
+		for _, lsm := range args.LSMOrder {
+			lsms = append(lsms, string(lsm))
+		}
+		flags = append(flags, daemonFlag{"lsm", strings.Join(lsms, ","), "kubearmorArgs.lsmOrder"})
+	}
+	if args.EnableKubeArmorPolicy != nil {
+		flags = append(flags, daemonFlag{"enableKubeArmorPolicy", strconv.FormatBool(*args.EnableKubeArmorPolicy), "kubearmorArgs.enableKubeArmorPolicy"})
+	}
+	if args.DefaultPostureLogs != nil {
+		flags = append(flags, daemonFlag{"defaultPostureLogs", strconv.FormatBool(*args.DefaultPostureLogs), "kubearmorArgs.defaultPostureLogs"})
+	}
+	if len(args.UntrackedNamespaces) > 0 {
+		flags = append(flags, daemonFlag{"untrackedNs", strings.Join(args.UntrackedNamespaces, ","), "kubearmorArgs.untrackedNamespaces"})
+	}
+	if args.Debug {
+		flags = append(flags, daemonFlag{"debug", "true", "kubearmorArgs.debug"})
+	}
+
+	host := spec.HostSecurity
+	if host.Enable {
+		flags = append(flags, daemonFlag{"enableKubeArmorHostPolicy", "true", "hostSecurity.enable"})
+	}
+	for _, flag := range []daemonFlag{
+		{"hostDefaultFilePosture", string(host.DefaultFilePosture), "hostSecurity.defaultFilePosture"},
+		{"hostDefaultCapabilitiesPosture", string(host.DefaultCapabilitiesPosture), "hostSecurity.defaultCapabilitiesPosture"},
+		{"hostDefaultNetworkPosture", string(host.DefaultNetworkPosture), "hostSecurity.defaultNetworkPosture"},
+		{"hostVisibility", host.Visibility, "hostSecurity.visibility"},
+	} {
+		if flag.value != "" {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// DaemonArgs returns the KubeArmor container arguments of kubearmorArgs and
+// hostSecurity, the extra arguments last
+func DaemonArgs(spec operatorv1.KubeArmorConfigSpec) []string {
+	daemonArgs := []string{}
+	for _, flag := range typedFlags(spec) {
+		daemonArgs = append(daemonArgs, fmt.Sprintf("-%s=%s", flag.name, flag.value))
+	}
+	return append(daemonArgs, spec.KubeArmorArgs.ExtraArgs...)
+}
+
+func flagName(arg string) string {
+	return strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+}
+
+// checkDaemonArgs checks that the arguments are well formed flags not set by
+// the chart or by another source in fields, and adds them to fields. Arguments
+// generated by a field of the spec are attributed to it in sources
+func checkDaemonArgs(args []string, source string, sources, fields map[string]string) error {
+	for _, arg := range args {
+		argSource, ok := sources[arg]
+		if !ok {
+			argSource = source
+		}
+		if !extraArgPattern.MatchString(arg) {
+			return fmt.Errorf("invalid argument %q of %s, expected -flag or -flag=value", arg, argSource)
+		}
+		name := flagName(arg)
+		if chartManagedFlags[name] {
+			return fmt.Errorf("argument %s of %s is set by the chart", arg, argSource)
+		}
+		if field, ok := fields[name]; ok {
+			return fmt.Errorf("argument %s of %s is already set by %s", arg, argSource, field)
+		}
+		fields[name] = argSource
+	}
+	return nil
+}
+
+// checkFlags checks that the flags are known daemon flags supported by the
+// KubeArmor version. allowUnknown passes unknown flags, a nil version skips the
+// version check
+func checkFlags(fields map[string]string, version *semver.Version, allowUnknown bool) error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		introduced, ok := flagVersions[name]
+		if !ok {
+			if allowUnknown {
+				continue
+			}
+			return fmt.Errorf("unknown flag -%s of %s, set kubearmorArgs.allowUnknownFlags to pass it anyway", name, fields[name])
+		}
+		if version != nil && version.LessThan(introduced) {
+			return fmt.Errorf("flag -%s of %s requires KubeArmor %s or newer, found %s", name, fields[name], introduced.Original(), version.Original())
+		}
+	}
+	return nil
+}
+
+// ValidateDaemonArgs checks that the arguments of the KubeArmor containers are
+// well formed flags not set by the chart or twice, and that they are known
+// flags supported by the given KubeArmor version. args are the kubearmor.args value the spec
+// is merged into, node profile arguments override them. A nil version skips
+// the version check
+func ValidateDaemonArgs(spec operatorv1.KubeArmorConfigSpec, args []string, version *semver.Version) error {
+	sources := map[string]string{}
+	fields := map[string]string{}
+	for _, flag := range typedFlags(spec) {
+		sources[fmt.Sprintf("-%s=%s", flag.name, flag.value)] = flag.field
+		fields[flag.name] = flag.field
+	}
+	if err := checkDaemonArgs(spec.KubeArmorArgs.ExtraArgs, "kubearmorArgs.extraArgs", map[string]string{}, fields); err != nil {
+		return err
+	}
+	for _, arg := range spec.KubeArmorArgs.ExtraArgs {
+		sources[arg] = "kubearmorArgs.extraArgs"
+	}
+
+	// the spec may be replaced or extended by the kubearmor.args value
+	fields = map[string]string{}
+	if err := checkDaemonArgs(args, "values kubearmor.args", sources, fields); err != nil {
+		return err
+	}
+	allowUnknown := spec.KubeArmorArgs.AllowUnknownFlags
+	if err := checkFlags(fields, version, allowUnknown); err != nil {
+		return err
+	}
+
+	for _, profile := range spec.NodeProfiles {
+		field := fmt.Sprintf("nodeProfiles[%s]", profile.Name)
+		fields := map[string]string{}
+		for _, flag := range []struct{ name, value string }{
+			{"defaultFilePosture", string(profile.DefaultFilePosture)},
+			{"defaultCapabilitiesPosture", string(profile.DefaultCapabilitiesPosture)},
+			{"defaultNetworkPosture", string(profile.DefaultNetworkPosture)},
+			{"visibility", profile.DefaultVisibility},
+		} {
+			if flag.value != "" {
+				fields[flag.name] = field + "." + flag.name
+			}
+		}
+		if err := checkDaemonArgs(profile.Args, field+".args", map[string]string{}, fields); err != nil {
+			return err
+		}
+		if err := checkFlags(fields, version, allowUnknown); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kubeArmorVersion returns the version of the KubeArmor image tag in the
+// given values, falling back to the chart app version for tags like stable.
+// It is nil if neither is a semantic version
+func kubeArmorVersion(c *chart.Chart, values map[string]interface{}) *semver.Version {
+	if tag, ok, _ := unstructured.NestedString(values, "kubearmor", "image", "tag"); ok {
+		if version, err := semver.NewVersion(tag); err == nil {
+			return version
+		}
+	}
+	if c == nil || c.Metadata == nil {
+		return nil
+	}
+	version, err := semver.NewVersion(c.Metadata.AppVersion)
+	if err != nil {
+		return nil
+	}
+	return version
+}
+
+// ValidateKubeArmorArgs validates the daemon arguments of the kubearmorconfig
+// merged with the given user values, against the KubeArmor version they deploy
+func (ctrl *Controller) ValidateKubeArmorArgs(kaConfig *operatorv1.KubeArmorConfig, userValues map[string]interface{}) error {
+	ctrl.stateMutex.Lock()
+	c := ctrl.chart
+	ctrl.stateMutex.Unlock()
+
+	listKeys := listMergeKeys(kaConfig.Spec)
+	values := map[string]interface{}{}
+	if c != nil {
+		values = mergeMaps(values, c.Values)
+	}
+	if args := DaemonArgs(kaConfig.Spec); len(args) > 0 {
+		values = mergeMapsWithListKeys(values, map[string]interface{}{
+			"kubearmor": map[string]interface{}{"args": args},
+		}, "", listKeys)
+	}
+	values = mergeMapsWithListKeys(values, userValues, "", listKeys)
+	return ValidateDaemonArgs(kaConfig.Spec, valuesDaemonArgs(values), kubeArmorVersion(c, values))
+}
+
+// rendersValue reports whether the chart templates use the value at the given
+// path, e.g. .Values.kubearmor.args is ignored by older charts
+func rendersValue(c *chart.Chart, path string) bool {
+	if c == nil {
+		return true
+	}
+	for _, template := range c.Templates {
+		if strings.Contains(string(template.Data), path) {
+			return true
+		}
+	}
+	return false
+}
+
+// valuesDaemonArgs returns the kubearmor.args value
+func valuesDaemonArgs(values map[string]interface{}) []string {
+	kubearmor, ok := values["kubearmor"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	list, ok := toList(kubearmor["args"])
+	if !ok {
+		return nil
+	}
+	args := make([]string, 0, len(list))
+	for _, arg := range list {
+		args = append(args, fmt.Sprint(arg))
+	}
+	return args
+}
+
+// appendDaemonArgs appends arguments to the KubeArmor container of a rendered
+// KubeArmor daemonset, other resources are returned unchanged
+func appendDaemonArgs(doc []byte, args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return doc, nil
+	}
+	ok, err := targetMatches(doc, daemonSetTarget)
+	if err != nil || !ok {
+		return doc, err
+	}
+	ds := unstructured.Unstructured{}
+	if err := ds.UnmarshalJSON(doc); err != nil {
+		return nil, err
+	}
+	if err := updateKubeArmorContainer(&ds, func(container map[string]interface{}) error {
+		current, _, err := unstructured.NestedStringSlice(container, "args")
+		if err != nil {
+			return err
+		}
+		return unstructured.SetNestedStringSlice(container, append(current, args...), "args")
+	}); err != nil {
+		return nil, err
+	}
+	return ds.MarshalJSON()
+}
+
+// updateKubeArmorContainer calls update with the KubeArmor container of a
+// daemonset
+func updateKubeArmorContainer(ds *unstructured.Unstructured, update func(container map[string]interface{}) error) error {
+	containers, _, err := unstructured.NestedSlice(ds.Object, "spec", "template", "spec", "containers")
+	if err != nil {
+		return err
+	}
+	for i, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok || container["name"] != "kubearmor" {
+			continue
+		}
+		if err := update(container); err != nil {
+			return err
+		}
+		containers[i] = container
+	}
+	return unstructured.SetNestedSlice(ds.Object, containers, "spec", "template", "spec", "containers")
+}
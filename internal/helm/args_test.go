@@ -0,0 +1,137 @@
+package helm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	semver "github.com/Masterminds/semver/v3"
+	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/chart"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+)
+
+func TestDaemonArgs(t *testing.T) {
+	enabled := false
+	args := operatorv1.KubeArmorArgs{
+		LSMOrder:              []operatorv1.LSM{"bpf", "apparmor"},
+		EnableKubeArmorPolicy: &enabled,
+		UntrackedNamespaces:   []string{"kube-system", "kubearmor"},
+		ExtraArgs:             []string{"-enableKubeArmorHostPolicy"},
+	}
+	spec := operatorv1.KubeArmorConfigSpec{KubeArmorArgs: args}
+	assert.Equal(t, []string{
+		"-lsm=bpf,apparmor",
+		"-enableKubeArmorPolicy=false",
+		"-untrackedNs=kube-system,kubearmor",
+		"-enableKubeArmorHostPolicy",
+	}, DaemonArgs(spec))
+
+	ctrl := &Controller{}
+	ctrl.UpdateHelmValuesFromKubeArmorConfig(&operatorv1.KubeArmorConfig{Spec: spec})
+	assert.Equal(t, DaemonArgs(spec), valuesDaemonArgs(ctrl.values()))
+}
+
+func TestValidateDaemonArgs(t *testing.T) {
+	v138 := semver.MustParse("v1.3.8")
+	v120 := semver.MustParse("v1.2.0")
+
+	tests := []struct {
+		name     string
+		args     operatorv1.KubeArmorArgs
+		host     operatorv1.HostSecurity
+		profiles []operatorv1.NodeProfile
+		// values replace the kubearmor.args generated from the spec if set
+		values  []string
+		version *semver.Version
+		err     string
+	}{
+		{name: "valid", args: operatorv1.KubeArmorArgs{LSMOrder: []operatorv1.LSM{"bpf"}, ExtraArgs: []string{"-enableKubeArmorHostPolicy", "--bpfFsPath=/sys/fs/bpf"}}, version: v138},
+		{name: "malformed", args: operatorv1.KubeArmorArgs{ExtraArgs: []string{"enableKubeArmorHostPolicy"}}, version: v138, err: "expected -flag or -flag=value"},
+		{name: "chart flag", args: operatorv1.KubeArmorArgs{ExtraArgs: []string{"-tlsEnabled=false"}}, version: v138, err: "set by the chart"},
+		{name: "duplicate", args: operatorv1.KubeArmorArgs{LSMOrder: []operatorv1.LSM{"bpf"}, ExtraArgs: []string{"-lsm=apparmor"}}, version: v138, err: "already set by kubearmorArgs.lsmOrder"},
+		{name: "unsupported", args: operatorv1.KubeArmorArgs{UntrackedNamespaces: []string{"kube-system"}}, version: v120, err: "flag -untrackedNs of kubearmorArgs.untrackedNamespaces requires KubeArmor v1.3.0"},
+		{name: "unsupported extra", args: operatorv1.KubeArmorArgs{ExtraArgs: []string{"-untrackedNs=kube-system"}}, version: v120, err: "of kubearmorArgs.extraArgs requires"},
+		{name: "host duplicate", args: operatorv1.KubeArmorArgs{ExtraArgs: []string{"-enableKubeArmorHostPolicy"}}, host: operatorv1.HostSecurity{Enable: true}, version: v138, err: "already set by hostSecurity.enable"},
+		{name: "unknown version", args: operatorv1.KubeArmorArgs{UntrackedNamespaces: []string{"kube-system"}}},
+		{name: "unknown", args: operatorv1.KubeArmorArgs{ExtraArgs: []string{"-lsmorder=bpf"}}, version: v138, err: "unknown flag -lsmorder of kubearmorArgs.extraArgs"},
+		{name: "unknown without version", args: operatorv1.KubeArmorArgs{ExtraArgs: []string{"-lsmorder=bpf"}}, err: "unknown flag -lsmorder"},
+		{name: "unknown allowed", args: operatorv1.KubeArmorArgs{ExtraArgs: []string{"-lsmorder=bpf"}, AllowUnknownFlags: true}, version: v138},
+		{name: "values", values: []string{"-lsm=bpf", "-enableKubeArmorHostPolicy"}, version: v138},
+		{name: "values chart flag", values: []string{"-gRPC=32767"}, version: v138, err: "argument -gRPC=32767 of values kubearmor.args is set by the chart"},
+		{name: "values duplicate", args: operatorv1.KubeArmorArgs{LSMOrder: []operatorv1.LSM{"bpf"}}, values: []string{"-lsm=bpf", "-lsm=apparmor"}, version: v138, err: "argument -lsm=apparmor of values kubearmor.args is already set by kubearmorArgs.lsmOrder"},
+		{name: "values unsupported", values: []string{"-untrackedNs=kube-system"}, version: v120, err: "flag -untrackedNs of values kubearmor.args requires"},
+		{name: "profile", args: operatorv1.KubeArmorArgs{LSMOrder: []operatorv1.LSM{"bpf"}}, profiles: []operatorv1.NodeProfile{{Name: "gpu", DefaultFilePosture: "audit", Args: []string{"-lsm=apparmor"}}}, version: v138},
+		{name: "profile chart flag", profiles: []operatorv1.NodeProfile{{Name: "gpu", Args: []string{"-tlsCertPath=/tmp"}}}, version: v138, err: "of nodeProfiles[gpu].args is set by the chart"},
+		{name: "profile duplicate", profiles: []operatorv1.NodeProfile{{Name: "gpu", DefaultFilePosture: "audit", Args: []string{"-defaultFilePosture=block"}}}, version: v138, err: "already set by nodeProfiles[gpu].defaultFilePosture"},
+		{name: "profile unknown", profiles: []operatorv1.NodeProfile{{Name: "gpu", Args: []string{"-defaultFilePostur=block"}}}, version: v138, err: "unknown flag -defaultFilePostur of nodeProfiles[gpu].args"},
+		{name: "profile unknown allowed", args: operatorv1.KubeArmorArgs{AllowUnknownFlags: true}, profiles: []operatorv1.NodeProfile{{Name: "gpu", Args: []string{"-newFlag=true"}}}, version: v138},
+		{name: "profile unsupported", profiles: []operatorv1.NodeProfile{{Name: "gpu", Args: []string{"-defaultPostureLogs=true"}}}, version: semver.MustParse("v1.0.0"), err: "flag -defaultPostureLogs of nodeProfiles[gpu].args requires KubeArmor v1.1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := operatorv1.KubeArmorConfigSpec{KubeArmorArgs: tt.args, HostSecurity: tt.host, NodeProfiles: tt.profiles}
+			args := tt.values
+			if args == nil {
+				args = DaemonArgs(spec)
+			}
+			err := ValidateDaemonArgs(spec, args, tt.version)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateKubeArmorArgs(t *testing.T) {
+	ctrl := &Controller{}
+	config := &operatorv1.KubeArmorConfig{Spec: operatorv1.KubeArmorConfigSpec{
+		KubeArmorArgs: operatorv1.KubeArmorArgs{LSMOrder: []operatorv1.LSM{"bpf"}},
+	}}
+	values := map[string]interface{}{"kubearmor": map[string]interface{}{"args": []interface{}{"-tlsEnabled=false"}}}
+	assert.ErrorContains(t, ctrl.ValidateKubeArmorArgs(config, values), "set by the chart")
+
+	// merged with the generated arguments
+	values = map[string]interface{}{"kubearmor": map[string]interface{}{"args": []interface{}{"-lsm=apparmor"}}}
+	assert.NoError(t, ctrl.ValidateKubeArmorArgs(config, values))
+	config.Spec.ValuesListMerge = []operatorv1.ValuesListMerge{{Path: "kubearmor.args"}}
+	assert.ErrorContains(t, ctrl.ValidateKubeArmorArgs(config, values), "already set by kubearmorArgs.lsmOrder")
+}
+
+func TestKubeArmorVersion(t *testing.T) {
+	c := &chart.Chart{Metadata: &chart.Metadata{AppVersion: "v1.3.8"}}
+	stable := map[string]interface{}{"kubearmor": map[string]interface{}{"image": map[string]interface{}{"tag": "stable"}}}
+	tagged := map[string]interface{}{"kubearmor": map[string]interface{}{"image": map[string]interface{}{"tag": "v1.2.1"}}}
+
+	assert.Equal(t, "v1.3.8", kubeArmorVersion(c, stable).Original())
+	assert.Equal(t, "v1.2.1", kubeArmorVersion(c, tagged).Original())
+	assert.Nil(t, kubeArmorVersion(&chart.Chart{Metadata: &chart.Metadata{AppVersion: "latest"}}, stable))
+}
+
+func TestPostRendererDaemonArgs(t *testing.T) {
+	values := map[string]interface{}{"kubearmor": map[string]interface{}{"args": []interface{}{"-lsm=bpf"}}}
+	ignoring := &chart.Chart{Templates: []*chart.File{{Name: "templates/daemonset.yaml", Data: []byte("args:\n- -gRPC=32767")}}}
+	rendering := &chart.Chart{Templates: []*chart.File{{Name: "templates/daemonset.yaml", Data: []byte("{{- toYaml .Values.kubearmor.args }}")}}}
+
+	assert.Nil(t, newPostRenderer(&releaseState{chart: rendering, values: values}))
+
+	renderer := newPostRenderer(&releaseState{
+		chart:  ignoring,
+		values: values,
+		nodeProfiles: []operatorv1.NodeProfile{{
+			Name: "gpu",
+			Args: []string{"-lsm=apparmor"},
+		}},
+	})
+	out, err := renderer.Run(bytes.NewBufferString(manifests))
+	assert.NoError(t, err)
+	docs := strings.Split(out.String(), "---\n")[1:]
+	assert.Len(t, docs, 3)
+	assert.NotContains(t, docs[0], "-lsm")
+	assert.Contains(t, docs[1], "args:\n        - -lsm=bpf\n")
+	// profile arguments take precedence
+	assert.Contains(t, docs[2], "- -lsm=bpf\n        - -lsm=apparmor\n")
+}
@@ -0,0 +1,187 @@
+package helm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"sigs.k8s.io/yaml"
+)
+
+const cacheIndexFile = "index.yaml"
+
+// DefaultChartCacheDir is used to store pulled helm charts when no cache
+// directory has been configured
+var DefaultChartCacheDir = path.Join(os.TempDir(), "kubearmor", ".cache")
+
+// cacheEntry describes a chart archive stored in the chart cache
+type cacheEntry struct {
+	Name     string    `json:"name"`
+	Version  string    `json:"version"`
+	Digest   string    `json:"digest"`
+	File     string    `json:"file"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+type cacheIndex struct {
+	Entries []cacheEntry `json:"entries"`
+}
+
+// ChartCache stores pulled chart archives on disk keyed by chart name, version
+// and sha256 digest. Pointing the cache directory to a persistent volume lets
+// the operator reuse charts across restarts and start without network access
+type ChartCache struct {
+	mutex sync.Mutex
+	dir   string
+}
+
+// NewChartCache creates the cache directory (if not present) and returns a
+// chart cache backed by it
+func NewChartCache(dir string) (*ChartCache, error) {
+	if dir == "" {
+		dir = DefaultChartCacheDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating chart cache directory %s: %s", dir, err.Error())
+	}
+	return &ChartCache{dir: dir}, nil
+}
+
+// Store saves chart archive to the cache and marks it as the last used chart
+// for the given chart name
+func (c *ChartCache) Store(name, version string, archive []byte) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	sum := sha256.Sum256(archive)
+	digest := hex.EncodeToString(sum[:])
+	file := fmt.Sprintf("%s-%s-%s.tgz", name, version, digest[:12])
+
+	index, err := c.readIndex()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path.Join(c.dir, file)); err != nil {
+		if err := writeFileAtomic(path.Join(c.dir, file), archive); err != nil {
+			return "", err
+		}
+	}
+	index.upsert(cacheEntry{
+		Name:     name,
+		Version:  version,
+		Digest:   digest,
+		File:     file,
+		LastUsed: time.Now(),
+	})
+	return digest, c.writeIndex(index)
+}
+
+// Get loads the most recently used cached chart with the given name and
+// version. An empty version matches any version of the chart
+func (c *ChartCache) Get(name, version string) (*chart.Chart, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	index, err := c.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range index.sorted() {
+		if entry.Name != name || (version != "" && entry.Version != version) {
+			continue
+		}
+		chart, err := c.load(entry)
+		if err != nil {
+			// corrupted or removed archive, try an older one
+			continue
+		}
+		return chart, nil
+	}
+	return nil, fmt.Errorf("chart %s version %q not found in cache %s", name, version, c.dir)
+}
+
+// Last loads the last successfully used chart with the given name
+func (c *ChartCache) Last(name string) (*chart.Chart, error) {
+	return c.Get(name, "")
+}
+
+func (c *ChartCache) load(entry cacheEntry) (*chart.Chart, error) {
+	archive, err := os.ReadFile(path.Join(c.dir, entry.File))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(archive)
+	if digest := hex.EncodeToString(sum[:]); digest != entry.Digest {
+		return nil, fmt.Errorf("digest mismatch for cached chart %s: expected %s got %s", entry.File, entry.Digest, digest)
+	}
+	return loader.LoadArchive(bytes.NewReader(archive))
+}
+
+func (c *ChartCache) readIndex() (*cacheIndex, error) {
+	index := &cacheIndex{}
+	data, err := os.ReadFile(path.Join(c.dir, cacheIndexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("error parsing chart cache index: %s", err.Error())
+	}
+	return index, nil
+}
+
+func (c *ChartCache) writeIndex(index *cacheIndex) error {
+	data, err := yaml.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(c.dir, cacheIndexFile), data)
+}
+
+// upsert adds the entry to the index or refreshes the existing entry having
+// same name, version and digest
+func (i *cacheIndex) upsert(entry cacheEntry) {
+	for idx, e := range i.Entries {
+		if e.Name == entry.Name && e.Version == entry.Version && e.Digest == entry.Digest {
+			i.Entries[idx] = entry
+			return
+		}
+	}
+	i.Entries = append(i.Entries, entry)
+}
+
+// sorted returns index entries with the most recently used entry first
+func (i *cacheIndex) sorted() []cacheEntry {
+	entries := append([]cacheEntry{}, i.Entries...)
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].LastUsed.After(entries[b].LastUsed)
+	})
+	return entries
+}
+
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := os.CreateTemp(path.Dir(file), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,86 @@
+package helm
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/chartutil"
+
+	embedFs "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/embed"
+)
+
+func TestChartCache(t *testing.T) {
+	cache, err := NewChartCache(t.TempDir())
+	assert.NoError(t, err)
+
+	archive, err := embedFs.EmbedFs.ReadFile("kubearmor-v1.3.8.tgz")
+	assert.NoError(t, err)
+
+	_, err = cache.Get("kubearmor", "v1.3.8")
+	assert.Error(t, err)
+
+	digest, err := cache.Store("kubearmor", "v1.3.8", archive)
+	assert.NoError(t, err)
+	assert.Equal(t, "d0d48e09a2eab174929c269ef3594060b5edadb2560b43b79f514965b2469ad7", digest)
+
+	// cache is reused by a new instance, e.g. after operator restart
+	cache, err = NewChartCache(cache.dir)
+	assert.NoError(t, err)
+	chart, err := cache.Get("kubearmor", "v1.3.8")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.3.8", chart.Metadata.Version)
+
+	chart, err = cache.Last("kubearmor")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.3.8", chart.Metadata.Version)
+
+	_, err = cache.Get("kubearmor", "v1.3.9")
+	assert.Error(t, err)
+
+	// tampered archives are not loaded
+	assert.NoError(t, os.WriteFile(path.Join(cache.dir, "kubearmor-v1.3.8-"+digest[:12]+".tgz"), []byte("corrupt"), 0644))
+	_, err = cache.Last("kubearmor")
+	assert.Error(t, err)
+}
+
+func TestGetHelmChartFallback(t *testing.T) {
+	cache, err := NewChartCache(t.TempDir())
+	assert.NoError(t, err)
+
+	// unreachable repository falls back to the embedded chart
+	chart, err := GetHelmChart("http://127.0.0.1:1", "v1.3.8", "", "kubearmor", cache)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.3.8", chart.Metadata.Version)
+
+	// the embedded chart of the requested version wins over the last used one
+	chart.Metadata.Version = "v1.3.9"
+	file, err := chartutil.Save(chart, t.TempDir())
+	assert.NoError(t, err)
+	archive, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	_, err = cache.Store("kubearmor", "v1.3.9", archive)
+	assert.NoError(t, err)
+	chart, err = GetHelmChart("http://127.0.0.1:1", "v1.3.8", "", "kubearmor", cache)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.3.8", chart.Metadata.Version)
+
+	// which is used if neither has the requested version
+	chart, err = GetHelmChart("http://127.0.0.1:1", "v1.4.0", "", "kubearmor", cache)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.3.9", chart.Metadata.Version)
+}
+
+func TestUseChartVersion(t *testing.T) {
+	chart, err := getEmbeddedHelmChart("kubearmor", "v1.3.8")
+	assert.NoError(t, err)
+	ctrl := &Controller{repository: "embed", chartName: "kubearmor", chart: chart}
+
+	assert.NoError(t, ctrl.UseChartVersion("1.3.8"))
+	assert.Same(t, chart, ctrl.chart)
+
+	err = ctrl.UseChartVersion("v1.3.9")
+	assert.ErrorContains(t, err, "chart version v1.3.9 is not embedded, available versions: v1.3.8")
+	assert.Same(t, chart, ctrl.chart)
+}
@@ -4,27 +4,33 @@ package helm
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/restmapper"
-	"sigs.k8s.io/yaml"
+	"k8s.io/client-go/tools/record"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
-	semver "github.com/Masterminds/semver/v3"
 	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
 	embedFs "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/embed"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/metrics"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
@@ -38,8 +44,15 @@ import (
 var (
 	settings     = cli.New()
 	actionConfig = &action.Configuration{}
+	log          = logf.Log.WithName("helm")
 )
 
+// debugLog adapts the printf style helm action logger to logr, helm logs at
+// debug verbosity
+func debugLog(format string, v ...interface{}) {
+	log.V(1).Info(fmt.Sprintf(format, v...))
+}
+
 // Config provides configurations to initialize a helm controller instance
 type Config struct {
 	// chartRef or chart name
@@ -52,51 +65,283 @@ type Config struct {
 	Repository string
 	// chart directory if local chart
 	Directory string
+	// directory to cache pulled charts
+	CacheDir string
+	// disables caching pulled charts
+	DisableChartCache bool
+	// timeout for helm install and upgrade operations, defaults to 5 minutes
+	Timeout time.Duration
+	// wait for the release resources to become ready, up to the timeout
+	Wait bool
+	// helm storage driver, secret, configmap or sql, defaults to secret
+	StorageDriver string
+	// release revisions kept, 0 keeps all of them
+	MaxHistory int
+	// adopt resources of legacy installations instead of deleting them
+	AdoptLegacyResources bool
+	// recorder to emit events for helm operations
+	EventRecorder record.EventRecorder
+	// object events are emitted on until a kubearmorconfig is known
+	EventObject runtime.Object
 }
 
 // Controller contains helm chart configurations
 type Controller struct {
+	// mutex serializes helm operations on the release
 	mutex sync.Mutex
+	// stateMutex guards the chart, values and options releases are made from,
+	// they are updated while helm operations run
+	stateMutex sync.Mutex
 	// Helm release chartName
 	chartName string
 	// Helm release namespace
 	namespace string
 	// Helm chart
 	chart *chart.Chart
+	// chart source used to load chart versions requested by kubearmorconfig
+	repository string
+	directory  string
+	version    string
+	cache      *ChartCache
 	// Helm values generated using kubearmorconfig instance
 	kaConfigValues map[string]interface{}
+	// Helm values supplied by user using valuesFrom and values
+	userValues map[string]interface{}
 	// Helm values generated using node configuration
 	nodeConfigValues map[string]interface{}
+	// patches applied to rendered manifests
+	patches []operatorv1.Patch
+	// canary rolls out daemonset changes one daemonset at a time
+	canary bool
+	// paused defers all release changes, maintenanceWindows restrict when they
+	// are applied
+	paused             bool
+	maintenanceWindows []operatorv1.MaintenanceWindow
+	// failedGeneration is the kubearmorconfig generation whose canary rollout
+	// failed, its spec is not applied again until the generation changes
+	generation       int64
+	failedGeneration int64
+	// nodeProfiles override the configuration of the nodes they select
+	nodeProfiles []operatorv1.NodeProfile
+	// helm values lists merged by key instead of being replaced, keyed by path
+	listMergeKeys map[string]string
+	// event recorder and the object events are emitted on
+	recorder    record.EventRecorder
+	eventObject runtime.Object
+	// kubearmorconfig the values were generated from
+	kaConfig types.NamespacedName
+	// timeout for helm install and upgrade operations
+	timeout time.Duration
+	// wait for the release resources to become ready
+	wait bool
+	// release revisions kept, 0 keeps all of them
+	maxHistory int
+	// adopt resources of legacy installations instead of deleting them
+	adoptLegacyResources bool
+	// helm operation holding mutex, nil if none
+	operation atomic.Pointer[helmOperation]
+	// result of the last helm storage check, see StorageCheck
+	storageCheck storageCheckResult
+	// asynchronous upgrades, see RequestUpgrade
+	upgrades upgradeQueue
+}
+
+// releaseState is a consistent snapshot of what the release is made from
+type releaseState struct {
+	chart              *chart.Chart
+	values             map[string]interface{}
+	patches            []operatorv1.Patch
+	canary             bool
+	paused             bool
+	maintenanceWindows []operatorv1.MaintenanceWindow
+	rolloutFailed      bool
+	nodeProfiles       []operatorv1.NodeProfile
+	kaConfig           types.NamespacedName
+	// complete is false until both kubearmorconfig and node configuration
+	// values are known
+	complete bool
+}
+
+// helmOperation is a helm operation holding the controller mutex
+type helmOperation struct {
+	name    string
+	started time.Time
+}
+
+// storageCheckResult is the result of a helm storage check
+type storageCheckResult struct {
+	mutex   sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// defaultTimeout is the timeout for helm install and upgrade operations
+const defaultTimeout = 5 * time.Minute
+
+// storageCheckInterval is the interval the helm storage is checked at, readiness
+// probes in between reuse the last result
+const storageCheckInterval = 30 * time.Second
+
+// ChartCheck is a readiness check reporting whether the chart has been loaded
+func (ctrl *Controller) ChartCheck(_ *http.Request) error {
+	ctrl.stateMutex.Lock()
+	defer ctrl.stateMutex.Unlock()
+	if ctrl.chart == nil {
+		return fmt.Errorf("helm chart %s is not loaded", ctrl.chartName)
+	}
+	return nil
+}
+
+// StorageCheck is a readiness check reporting whether the helm release storage
+// is reachable, checked at most once per storageCheckInterval
+func (ctrl *Controller) StorageCheck(_ *http.Request) error {
+	return ctrl.checkStorage(time.Now())
+}
+
+func (ctrl *Controller) checkStorage(now time.Time) error {
+	ctrl.storageCheck.mutex.Lock()
+	defer ctrl.storageCheck.mutex.Unlock()
+	if !ctrl.storageCheck.checked.IsZero() && now.Sub(ctrl.storageCheck.checked) < storageCheckInterval {
+		return ctrl.storageCheck.err
+	}
+	ctrl.storageCheck.checked, ctrl.storageCheck.err = now, ctrl.storageReachable()
+	return ctrl.storageCheck.err
+}
+
+// storageReachable lists the release history to check the helm storage
+func (ctrl *Controller) storageReachable() error {
+	if actionConfig.KubeClient == nil || actionConfig.Releases == nil {
+		return fmt.Errorf("helm action config is not initialized")
+	}
+	if err := actionConfig.KubeClient.IsReachable(); err != nil {
+		return err
+	}
+	if _, err := actionConfig.Releases.History(ctrl.chartName); err != nil && err != driver.ErrReleaseNotFound {
+		return fmt.Errorf("helm release storage is not reachable: %s", err.Error())
+	}
+	return nil
+}
+
+// OperationCheck is a health check failing when a helm operation, like an
+// upgrade or the preinstall migrations, holds the controller mutex well beyond
+// the helm operation timeout
+func (ctrl *Controller) OperationCheck(_ *http.Request) error {
+	operation := ctrl.operation.Load()
+	if operation == nil {
+		return nil
+	}
+	if elapsed := time.Since(operation.started); elapsed > ctrl.timeout+time.Minute {
+		return fmt.Errorf("helm %s of release %s is stuck for %s", operation.name, ctrl.chartName, elapsed.Round(time.Second))
+	}
+	return nil
+}
+
+// lock acquires mutex for the named helm operation, the returned function
+// releases it
+func (ctrl *Controller) lock(operation string) func() {
+	ctrl.mutex.Lock()
+	ctrl.operation.Store(&helmOperation{name: operation, started: time.Now()})
+	return func() {
+		ctrl.operation.Store(nil)
+		ctrl.mutex.Unlock()
+	}
 }
 
 // NewHelmController creates an instance of helm controller using provided configurations
 // and return it on successful initialization otherwise returns an error
 func NewHelmController(cfg Config) (*Controller, error) {
-	err := actionConfig.Init(settings.RESTClientGetter(), cfg.Namespace, os.Getenv("HELM_DRIVER"), log.Printf)
+	err := initActionConfig(cfg.Namespace, cfg.StorageDriver, cfg.MaxHistory)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing helm action config: %s", err.Error())
 	}
-	chart, err := GetHelmChart(cfg.Repository, cfg.Version, cfg.Directory, cfg.ChartName)
+	var cache *ChartCache
+	if !cfg.DisableChartCache {
+		cache, err = NewChartCache(cfg.CacheDir)
+		if err != nil {
+			// charts can still be pulled, they just won't survive a restart
+			log.Error(err, "chart cache is disabled", "dir", cfg.CacheDir)
+		}
+	}
+	chart, err := GetHelmChart(cfg.Repository, cfg.Version, cfg.Directory, cfg.ChartName, cache)
 	if err != nil {
 		return nil, fmt.Errorf("error pulling helm chart: %s", err.Error())
 	}
 
-	log.Printf("helm controller has configured: %+v", cfg)
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	log.Info("helm controller has been configured", "chart", cfg.ChartName, "namespace", cfg.Namespace,
+		"version", cfg.Version, "repository", cfg.Repository, "directory", cfg.Directory, "cacheDir", cfg.CacheDir,
+		"storageDriver", cfg.StorageDriver, "maxHistory", cfg.MaxHistory)
 
 	return &Controller{
-		mutex:            sync.Mutex{},
-		chartName:        cfg.ChartName,
-		namespace:        cfg.Namespace,
-		chart:            chart,
-		kaConfigValues:   map[string]interface{}{},
-		nodeConfigValues: map[string]interface{}{},
+		mutex:                sync.Mutex{},
+		chartName:            cfg.ChartName,
+		namespace:            cfg.Namespace,
+		chart:                chart,
+		repository:           cfg.Repository,
+		directory:            cfg.Directory,
+		version:              cfg.Version,
+		cache:                cache,
+		recorder:             cfg.EventRecorder,
+		eventObject:          cfg.EventObject,
+		timeout:              timeout,
+		wait:                 cfg.Wait,
+		maxHistory:           cfg.MaxHistory,
+		adoptLegacyResources: cfg.AdoptLegacyResources,
+		kaConfigValues:       map[string]interface{}{},
+		userValues:           map[string]interface{}{},
+		nodeConfigValues:     map[string]interface{}{},
 	}, nil
 }
 
-type resource struct {
-	kind  string
-	name  string
-	group string
+// UseChartVersion switches the chart used for upcoming releases to the given
+// version. An empty version selects the version the operator was configured with
+func (ctrl *Controller) UseChartVersion(version string) error {
+	if version == "" {
+		version = ctrl.version
+	}
+	ctrl.stateMutex.Lock()
+	defer ctrl.stateMutex.Unlock()
+
+	if ctrl.directory != "" || version == "" || embedFs.SameVersion(ctrl.chart.Metadata.Version, version) {
+		return nil
+	}
+	var chart *chart.Chart
+	var err error
+	if ctrl.repository == "embed" {
+		chart, err = getEmbeddedHelmChart(ctrl.chartName, version)
+		if err != nil {
+			versions, _ := embedFs.Versions(ctrl.chartName)
+			return fmt.Errorf("chart version %s is not embedded, available versions: %s", version, strings.Join(versions, ", "))
+		}
+	} else {
+		chart, err = GetHelmChart(ctrl.repository, version, "", ctrl.chartName, ctrl.cache)
+		if err != nil {
+			return err
+		}
+		if !embedFs.SameVersion(chart.Metadata.Version, version) {
+			// GetHelmChart fell back to a different chart version
+			return fmt.Errorf("chart version %s is not available", version)
+		}
+	}
+	log.Info("switching chart version", "from", ctrl.chart.Metadata.Version, "to", chart.Metadata.Version)
+	ctrl.chart = chart
+	return nil
+}
+
+// recordEvent emits an event on the kubearmorconfig instance, or the configured
+// event object if no kubearmorconfig has been seen yet
+func (ctrl *Controller) recordEvent(eventtype, reason, messageFmt string, args ...interface{}) {
+	ctrl.stateMutex.Lock()
+	object := ctrl.eventObject
+	ctrl.stateMutex.Unlock()
+	if ctrl.recorder == nil || object == nil {
+		return
+	}
+	ctrl.recorder.Eventf(object, eventtype, reason, messageFmt, args...)
 }
 
 // UpdateHelmValuesFromKubeArmorConfig function merge helm values with new values
@@ -129,6 +374,26 @@ func (ctrl *Controller) UpdateHelmValuesFromKubeArmorConfig(kaConfig *operatorv1
 	if val := kaConfig.Spec.ThrottleSec; val != 0 {
 		configMapValues["throttleSec"] = val
 	}
+	// default host postures and visibility
+	if val := kaConfig.Spec.HostSecurity.DefaultFilePosture; val != "" {
+		configMapValues["hostDefaultFilePosture"] = string(val)
+	}
+	if val := kaConfig.Spec.HostSecurity.DefaultCapabilitiesPosture; val != "" {
+		configMapValues["hostDefaultCapabilitiesPosture"] = string(val)
+	}
+	if val := kaConfig.Spec.HostSecurity.DefaultNetworkPosture; val != "" {
+		configMapValues["hostDefaultNetworkPosture"] = string(val)
+	}
+	if val := kaConfig.Spec.HostSecurity.Visibility; val != "" {
+		configMapValues["hostVisibility"] = val
+	}
+
+	// kubearmor daemon flags => Values.kubearmor.args
+	if args := DaemonArgs(kaConfig.Spec); len(args) > 0 {
+		kaConfigHelmValues["kubearmor"] = map[string]interface{}{
+			"args": args,
+		}
+	}
 
 	// tls => Values.tls
 	kaConfigHelmValues["tls"] = map[string]interface{}{
@@ -168,39 +433,122 @@ func (ctrl *Controller) UpdateHelmValuesFromKubeArmorConfig(kaConfig *operatorv1
 	relay["enableStdoutMsg"] = strconv.FormatBool(kaConfig.Spec.EnableStdOutMsgs)
 	// handle seccomp
 
+	ctrl.stateMutex.Lock()
+	defer ctrl.stateMutex.Unlock()
 	ctrl.kaConfigValues = kaConfigHelmValues
+	ctrl.patches = kaConfig.Spec.Patches
+	ctrl.canary = kaConfig.Spec.Rollout != nil && kaConfig.Spec.Rollout.Type == operatorv1.CanaryRollout
+	ctrl.paused = Paused(kaConfig)
+	ctrl.maintenanceWindows = kaConfig.Spec.MaintenanceWindows
+	ctrl.nodeProfiles = kaConfig.Spec.NodeProfiles
+	ctrl.eventObject = kaConfig
+	name := types.NamespacedName{Namespace: kaConfig.Namespace, Name: kaConfig.Name}
+	if name != ctrl.kaConfig {
+		ctrl.failedGeneration = 0
+	}
+	if RolloutFailed(kaConfig) {
+		ctrl.failedGeneration = kaConfig.Generation
+	}
+	ctrl.generation = kaConfig.Generation
+	ctrl.kaConfig = name
+	ctrl.listMergeKeys = listMergeKeys(kaConfig.Spec)
+}
+
+// listMergeKeys returns the keys of the helm values lists merged by key,
+// keyed by path
+func listMergeKeys(spec operatorv1.KubeArmorConfigSpec) map[string]string {
+	keys := map[string]string{}
+	for _, listMerge := range spec.ValuesListMerge {
+		key := listMerge.Key
+		if key == "" {
+			key = "name"
+		}
+		keys[listMerge.Path] = key
+	}
+	return keys
+}
+
+// HaltUpgrades defers all release changes until the generation of the
+// kubearmorconfig changes, its rollout failed and upgrading again would reapply
+// the failed spec
+func (ctrl *Controller) HaltUpgrades(generation int64) {
+	ctrl.stateMutex.Lock()
+	defer ctrl.stateMutex.Unlock()
+	ctrl.failedGeneration = generation
+}
+
+// UpdateUserHelmValues sets raw helm values supplied by the user, these are
+// merged over the values generated from kubearmorconfig
+func (ctrl *Controller) UpdateUserHelmValues(values map[string]interface{}) {
+	ctrl.stateMutex.Lock()
+	defer ctrl.stateMutex.Unlock()
+	ctrl.userValues = values
 }
 
 func (ctrl *Controller) UpdateNodeConfigHelmValues(nodeConfig []map[string]interface{}) {
+	ctrl.stateMutex.Lock()
+	defer ctrl.stateMutex.Unlock()
 	ctrl.nodeConfigValues = map[string]interface{}{
 		"nodes": nodeConfig,
 	}
 }
 
-func pullHelmChartFromOCIRegistry(repository, version, chart, targetDir string) (*chart.Chart, error) {
-	// create a temp subdirectory to pull helm chart
-	file := path.Join(targetDir, fmt.Sprintf("%s-%s.tgz", chart, version))
-	actionCfg := &action.Configuration{}
+// pullHelmChart pulls chart archive from the given helm or OCI repository and
+// returns the archive content
+func pullHelmChart(repository, version, chartName string) ([]byte, error) {
+	// pull into a fresh directory so the pulled archive can be located
+	// irrespective of how helm names it
+	targetDir, err := os.MkdirTemp("", "kubearmor-chart-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(targetDir)
+
 	pull := action.NewPullWithOpts(action.WithConfig(actionConfig))
 	pull.Settings = settings
 	pull.Version = version
 	pull.DestDir = targetDir
-	// in case of private registries ??
-	client, err := registry.NewClient()
-	if err != nil {
-		return nil, err
+	if registry.IsOCI(repository) {
+		// in case of private registries ??
+		client, err := registry.NewClient()
+		if err != nil {
+			return nil, err
+		}
+		actionConfig.RegistryClient = client
+		_, err = pull.Run(repository)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		pull.RepoURL = repository
+		_, err = pull.Run(chartName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	archives, err := filepath.Glob(path.Join(targetDir, "*.tgz"))
+	if err != nil || len(archives) == 0 {
+		return nil, fmt.Errorf("no chart archive found after pulling %s from %s", chartName, repository)
 	}
-	actionCfg.RegistryClient = client
-	_, err = pull.Run(repository)
+	return os.ReadFile(archives[0])
+}
+
+// getEmbeddedHelmChart loads the chart with given name and version from the
+// embedded charts, or the latest embedded version of the chart if version is empty
+func getEmbeddedHelmChart(chartName, version string) (*chart.Chart, error) {
+	chartArchieve, _, err := embedFs.Chart(chartName, version)
 	if err != nil {
 		return nil, err
 	}
-	// load pulled helm chart from archieve file
-	return loader.Load(file)
+	return loader.LoadArchive(bytes.NewReader(chartArchieve))
 }
 
-// GetHelmChart pull helm chart from given helm parameters
-func GetHelmChart(repository, version, directory, chartName string) (*chart.Chart, error) {
+// GetHelmChart pull helm chart from given helm parameters. Pulled charts are stored
+// in the given cache and if the repository is unreachable the chart falls back to
+// the cached or embedded chart of the same version, and at last to the last used
+// cached chart or the latest embedded chart
+func GetHelmChart(repository, version, directory, chartName string, cache *ChartCache) (*chart.Chart, error) {
 	// TODO: validate chart version ^v1.3.8
 	// check if local helm chart is to be used
 	if directory != "" {
@@ -212,70 +560,85 @@ func GetHelmChart(repository, version, directory, chartName string) (*chart.Char
 	}
 
 	if repository == "embed" {
-		chartArchieve, err := embedFs.EmbedFs.ReadFile(fmt.Sprintf("%s-%s.tgz", chartName, version))
-		if err != nil {
-			return nil, err
-		}
-		return loader.LoadArchive(bytes.NewReader(chartArchieve))
+		return getEmbeddedHelmChart(chartName, version)
 	}
 
-	// create a cache directory to store pulled helm chart
-	targetDir := path.Join(os.TempDir(), "kubearmor", ".cache")
-	err := os.MkdirAll(targetDir, 0755)
-	if err != nil && !os.IsExist(err) {
-		targetDir = "./"
+	archive, pullErr := pullHelmChart(repository, version, chartName)
+	if pullErr == nil {
+		if cache != nil {
+			if _, err := cache.Store(chartName, version, archive); err != nil {
+				log.Error(err, "unable to store chart in cache", "chart", chartName, "version", version)
+			}
+		}
+		return loader.LoadArchive(bytes.NewReader(archive))
 	}
+	log.Error(pullErr, "error pulling helm chart", "chart", chartName, "version", version, "repository", repository)
 
-	if registry.IsOCI(repository) {
-		return pullHelmChartFromOCIRegistry(repository, version, chartName, targetDir)
+	// repository is unreachable, fallback to a chart of the same version
+	if cache != nil {
+		if chart, err := cache.Get(chartName, version); err == nil {
+			log.Info("using cached chart", "chart", chartName, "version", chart.Metadata.Version)
+			return chart, nil
+		}
 	}
-
-	file := path.Join(targetDir, fmt.Sprintf("%s-%s.tgz", chartName, version))
-	pull := action.NewPullWithOpts(action.WithConfig(actionConfig))
-	pull.Settings = settings
-	pull.Version = version
-	pull.RepoURL = repository
-	pull.DestDir = targetDir
-	// pull chart
-	_, err = pull.Run(chartName)
-	if err != nil {
-		log.Printf("error pulling helm chart: %s", err.Error())
-		return nil, err
+	if version != "" {
+		if chart, err := getEmbeddedHelmChart(chartName, version); err == nil {
+			log.Info("using embedded chart", "chart", chartName, "version", chart.Metadata.Version)
+			return chart, nil
+		}
+	}
+	// and at last to a previously used or the latest embedded one
+	if cache != nil {
+		if chart, err := cache.Last(chartName); err == nil {
+			logChartFallback("using last used cached chart", chartName, version, chart)
+			return chart, nil
+		}
+	}
+	if chart, err := getEmbeddedHelmChart(chartName, ""); err == nil {
+		logChartFallback("using latest embedded chart", chartName, version, chart)
+		return chart, nil
 	}
-	// load pulled helm chart from archieve file
-	return loader.Load(file)
+	return nil, pullErr
 }
 
-// checkIfCleanUpRequired check for recent two revisions of (if any) existing
-// kubearmor-operator release and check if last installed version is <v1.3.8
-func checkIfCleanUpRequired() bool {
-	v138, _ := semver.NewVersion("v1.3.8")
-	histClient := action.NewHistory(actionConfig)
-	histClient.Max = 10
-	release, err := histClient.Run("kubearmor-operator")
-	if err != nil && err == driver.ErrReleaseNotFound {
-		return false
+// logChartFallback logs the chart used in place of the pulled one, as an error
+// if its version differs from the requested one
+func logChartFallback(msg, chartName, version string, chart *chart.Chart) {
+	if version != "" && !embedFs.SameVersion(chart.Metadata.Version, version) {
+		log.Error(fmt.Errorf("chart version %s is not available", version), msg,
+			"chart", chartName, "version", chart.Metadata.Version)
+		return
 	}
-	for _, rel := range release {
-		ver, _ := semver.NewVersion(rel.Chart.Metadata.Version)
-		if ver.Equal(v138) {
-			continue
-		} else if ver.LessThan(v138) {
-			return true
-		} else if ver.GreaterThan(v138) {
-			return false
-		}
-	}
-	return false
+	log.Info(msg, "chart", chartName, "version", chart.Metadata.Version)
 }
 
-func uninstallRelease(releaseName string) error {
+// UninstallRelease uninstalls the KubeArmor release
+func (ctrl *Controller) UninstallRelease() error {
+	defer ctrl.lock("uninstall")()
 	uninstallClient := action.NewUninstall(actionConfig)
-	_, err := uninstallClient.Run(releaseName)
+	uninstallClient.Wait = ctrl.wait
+	uninstallClient.Timeout = ctrl.timeout
+	_, err := uninstallClient.Run(ctrl.chartName)
+	return err
+}
+
+// ReleaseHistory returns the latest max revisions of the KubeArmor release, oldest first
+func (ctrl *Controller) ReleaseHistory(max int) ([]*release.Release, error) {
+	releases, err := action.NewHistory(actionConfig).Run(ctrl.chartName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	releaseutil.SortByRevision(releases)
+	if max > 0 && len(releases) > max {
+		releases = releases[len(releases)-max:]
+	}
+	return releases, nil
+}
+
+// Release returns the latest revision of the KubeArmor release,
+// driver.ErrReleaseNotFound if it is not installed
+func (ctrl *Controller) Release() (*release.Release, error) {
+	return action.NewGet(actionConfig).Run(ctrl.chartName)
 }
 
 func removeManifestHeader(manifest string) string {
@@ -288,154 +651,98 @@ func removeManifestHeader(manifest string) string {
 		}
 		cleanedLines = append(cleanedLines, line)
 	}
-	// log.Printf("resource after header removed: \n%s\n", strings.Join(cleanedLines, "\n"))
 	return strings.Join(cleanedLines, "\n")
 }
 
-func (ctrl *Controller) cleanUpResources(ctx context.Context, actionConfig *action.Configuration) ([]resource, error) {
-	installClient := action.NewInstall(actionConfig)
-	installClient.Namespace = ctrl.namespace
-	installClient.ReleaseName = ctrl.chartName
-	installClient.ClientOnly = true
-	installClient.DryRun = true
-
-	rel, _ := installClient.RunWithContext(ctx, ctrl.chart, ctrl.kaConfigValues)
-
-	var resources []resource
-	if rel != nil {
-		manifests := releaseutil.SplitManifests(rel.Manifest)
-		for _, manifest := range manifests {
-			cleanManifest := removeManifestHeader(manifest)
-			if strings.TrimSpace(cleanManifest) == "" {
-				continue
-			}
-			u := unstructured.Unstructured{}
-			jsonData, err := yaml.YAMLToJSON([]byte(cleanManifest))
-			if err != nil {
-				return nil, fmt.Errorf("error converting YAML to JSON: %v", err)
-			}
-			_, _, err = unstructured.UnstructuredJSONScheme.Decode([]byte(jsonData), nil, &u)
-			if err != nil {
-				return nil, fmt.Errorf("error decoding manifest: %v", err)
-			}
-
-			resources = append(resources, resource{
-				kind:  u.GetKind(),
-				name:  u.GetName(),
-				group: u.GroupVersionKind().Group,
-			})
-		}
-		log.Printf("list of resources to clean: %d\n", len(resources))
-	}
-	return resources, nil
-}
-
-// Preinstall checks if previous operator was older than v1.3.4, in that case it requires deleting the KubeArmor k8s
-// resources to be deleted explicitly to avoid conflict between controller that manages resources as helm need to be
-// the controller to manage KubeArmor k8s resources
+// Preinstall applies the registered migrations the installed KubeArmor requires
+// before the release can be installed or upgraded with the loaded chart
 func (ctrl *Controller) Preinstall() error {
-	err := actionConfig.Init(settings.RESTClientGetter(), ctrl.namespace, "", func(format string, v ...interface{}) {})
-	if err != nil {
-		log.Fatalf("error initializing action config: %s", err.Error())
-	}
 	config, err := settings.RESTClientGetter().ToRESTConfig()
 	if err != nil {
 		return err
 	}
-
-	required := checkIfCleanUpRequired()
-	if !required {
-		return nil
-	}
-
 	dynamicClient, err := dynamic.NewForConfig(config)
 	if err != nil {
 		return err
 	}
-
-	discoveryClient, err := settings.RESTClientGetter().ToDiscoveryClient()
-	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
-	resources, err := ctrl.cleanUpResources(context.Background(), actionConfig)
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		log.Printf("error getting resources: %s\n", err.Error())
-	}
-	for _, resource := range resources {
-		mapping, err := mapper.RESTMapping(schema.GroupKind{Group: resource.group, Kind: resource.kind})
-		if err != nil {
-			fmt.Printf("failed to get mapping to kind: %s: %s\n", resource.kind, err.Error())
-			continue
-		}
-		resourceClient := dynamicClient.Resource(mapping.Resource).Namespace(ctrl.namespace)
-		err = resourceClient.Delete(context.TODO(), resource.name, metav1.DeleteOptions{})
-		if err != nil && !errors.IsNotFound(err) {
-			return fmt.Errorf("failed to delete %s %s: %v", resource.kind, resource.name, err)
-		}
-		fmt.Printf("Successfully deleted %s: %s\n", resource.kind, resource.name)
-	}
-
-	// === handle kubearmor daemonset and controller seperately ===
-
-	// GVR for daemonsets
-	dsGvr := schema.GroupVersionResource{
-		Group:    "apps",
-		Version:  "v1",
-		Resource: "daemonsets",
+		return err
 	}
-	daemonSetClient := dynamicClient.Resource(dsGvr).Namespace(ctrl.namespace)
-	daemonSets, err := daemonSetClient.List(context.TODO(), metav1.ListOptions{
-		LabelSelector: "kubearmor-app=kubearmor",
-	})
+	discoveryClient, err := settings.RESTClientGetter().ToDiscoveryClient()
 	if err != nil {
-		log.Printf("failed to list kubearmor daemonsets error=%s", err.Error())
 		return err
 	}
-	for _, ds := range daemonSets.Items {
-		err := daemonSetClient.Delete(context.Background(), ds.GetName(), metav1.DeleteOptions{})
-		if err != nil {
-			log.Printf("error deleteing daemonset %s error=%s", ds.GetName(), err.Error())
-			return err
-		}
-		fmt.Printf("Successfully deleted %s: %s\n", ds.GetKind(), ds.GetName())
-	}
 
-	// GVR for deployments
-	depGvr := schema.GroupVersionResource{
-		Group:    "apps",
-		Version:  "v1",
-		Resource: "deployments",
+	migrator := &Migrator{
+		Migrations:        Migrations,
+		ActionConfig:      actionConfig,
+		Clientset:         clientset,
+		Namespace:         ctrl.namespace,
+		ReleaseName:       ctrl.chartName,
+		LegacyReleaseName: LegacyReleaseName,
+	}
+	defer ctrl.lock("preinstall")()
+	state := ctrl.snapshot()
+	applied, err := migrator.Run(context.Background(), state.chart.Metadata.Version, &MigrationContext{
+		Namespace:   ctrl.namespace,
+		ReleaseName: ctrl.chartName,
+		Render: func(ctx context.Context) (*release.Release, error) {
+			return ctrl.render(ctx, state)
+		},
+		Dynamic: dynamicClient,
+		Mapper:  restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
+		Event: func(reason, messageFmt string, args ...interface{}) {
+			ctrl.recordEvent(corev1.EventTypeNormal, reason, messageFmt, args...)
+		},
+		Adopt: ctrl.adoptLegacyResources,
+	})
+	for _, name := range applied {
+		ctrl.recordEvent(corev1.EventTypeNormal, defaults.MigrationAppliedReason, "applied migration %s to the existing KubeArmor installation", name)
 	}
-	deployClient := dynamicClient.Resource(depGvr).Namespace(ctrl.namespace)
-	err = deployClient.Delete(context.Background(), "kubearmor-controller", metav1.DeleteOptions{})
-	if err != nil && !errors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete deployment kubearmor-controller %s", err.Error())
+	// revisions accumulated before the history was limited are otherwise only
+	// pruned by the next upgrade
+	if pruned, err := pruneHistory(actionConfig.Releases, ctrl.chartName, ctrl.maxHistory); err != nil {
+		log.Error(err, "unable to prune release history", "release", ctrl.chartName)
+	} else if pruned > 0 {
+		log.Info("pruned release history", "release", ctrl.chartName, "revisions", pruned, "maxHistory", ctrl.maxHistory)
 	}
-	fmt.Printf("Successfully deleted %s: %s\n", "Deployment", "kubearmor-controller")
-	return nil
+	return err
 }
 
-// UpgradeRelease performs helm upgrade for helm chart defined with configuration
+// UpgradeRelease performs helm upgrade for helm chart defined with configuration.
+// It returns a DeferredError without changing the release if reconciliation is
+// paused or outside of the maintenance windows
 func (ctrl *Controller) UpgradeRelease(ctx context.Context) (*release.Release, error) {
-	ctrl.mutex.Lock()
-	defer ctrl.mutex.Unlock()
-
-	histClient := action.NewHistory(actionConfig)
-	histClient.Max = 1
-	release, err := histClient.Run(ctrl.chartName)
-
-	var vals map[string]interface{}
-	vals = mergeMaps(ctrl.kaConfigValues, ctrl.nodeConfigValues)
+	defer ctrl.lock("upgrade")()
+	state := ctrl.snapshot()
+	if err := ctrl.checkDeferral(state, time.Now()); err != nil {
+		return nil, err
+	}
 
 	// Not a best way to sync between kubearmorconfig reconiler and clusterwatcher
 	// to check and deploy KubeArmor applications only if snitch detected node configuration
 	// and kubearmoconfig CR instance has been detected
-	if len(ctrl.kaConfigValues) < 1 || len(ctrl.nodeConfigValues) < 1 {
-		return nil, fmt.Errorf("either nodes are not processed or kubearmorconfig CR instance not present")
+	if !state.complete {
+		return nil, ErrValuesIncomplete
 	}
 
-	fmt.Printf("vals: %+n", vals)
+	rel, err := action.NewGet(actionConfig).Run(ctrl.chartName)
+
+	vals := state.values
+
+	log.V(1).Info("computed helm values", "values", RedactValues(vals))
+
+	// render manifests before touching the release so that invalid patches
+	// are reported without applying anything
+	if len(state.patches) > 0 {
+		if _, err := ctrl.render(ctx, state); err != nil {
+			return nil, fmt.Errorf("error validating patches: %s", err.Error())
+		}
+	}
 
-	if err != nil && err == driver.ErrReleaseNotFound {
-		fmt.Println("no existing kubearmor release installing now")
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		log.Info("no existing release, installing", "release", ctrl.chartName)
 		// release not found install release
 		installClient := action.NewInstall(actionConfig)
 		if installClient == nil {
@@ -443,37 +750,183 @@ func (ctrl *Controller) UpgradeRelease(ctx context.Context) (*release.Release, e
 		}
 		installClient.Namespace = ctrl.namespace
 		installClient.ReleaseName = ctrl.chartName
-		installClient.Wait = true
-		installClient.Timeout = 5 * time.Minute
+		installClient.Wait = ctrl.wait
+		installClient.Timeout = ctrl.timeout
+		installClient.PostRenderer = newPostRenderer(state)
 		// installClient.Atomic = true
-		// return installClient.RunWithContext(ctx, ctrl.chart, vals)
-		return installClient.Run(ctrl.chart, vals)
+		start := time.Now()
+		return ctrl.observeRelease("install", start)(installClient.RunWithContext(ctx, state.chart, vals))
 	}
-	fmt.Println("found existing kubearmor release upgrading now")
-	if release[0].Info.Status != "deployed" {
-		return nil, fmt.Errorf("previous release status is not deployed but %s", release[0].Info.Status)
+	if err != nil {
+		return nil, err
 	}
+	if rel.Info.Status.IsPending() {
+		log.Info("recovering pending release", "release", ctrl.chartName, "revision", rel.Version, "status", rel.Info.Status)
+		if rel, err = recoverPendingRelease(actionConfig, ctrl.newRollback(), rel); err != nil {
+			return nil, fmt.Errorf("failed to recover pending release: %s", err.Error())
+		}
+	}
+	// upgrades proceed over failed revisions like helm upgrade does
+	if status := rel.Info.Status; status != release.StatusDeployed && status != release.StatusFailed {
+		return nil, fmt.Errorf("latest release revision %d is %s", rel.Version, status)
+	}
+	log.Info("found existing release, upgrading", "release", ctrl.chartName, "revision", rel.Version, "status", rel.Info.Status)
 	upgradeClient := action.NewUpgrade(actionConfig)
 	// upgradeClient.Atomic = true
 	upgradeClient.ResetValues = true
-	upgradeClient.Wait = true
-	upgradeClient.Timeout = 5 * time.Minute
+	upgradeClient.Wait = ctrl.wait
+	upgradeClient.Timeout = ctrl.timeout
+	upgradeClient.MaxHistory = ctrl.maxHistory
 	upgradeClient.Namespace = ctrl.namespace
-	return upgradeClient.RunWithContext(ctx, ctrl.chartName, ctrl.chart, vals)
+	upgradeClient.PostRenderer = newPostRenderer(state)
+	start := time.Now()
+	return ctrl.observeRelease("upgrade", start)(upgradeClient.RunWithContext(ctx, ctrl.chartName, state.chart, vals))
 }
 
-// mergeMaps
+// RollbackRelease rolls the KubeArmor release back to its previous revision and
+// returns the revision created by the rollback
+func (ctrl *Controller) RollbackRelease(ctx context.Context) (*release.Release, error) {
+	defer ctrl.lock("rollback")()
+
+	log.Info("rolling back release", "release", ctrl.chartName)
+	rollbackClient := ctrl.newRollback()
+	// version 0 is the previous revision
+	rollbackClient.Version = 0
+	start := time.Now()
+	if err := rollbackClient.Run(ctrl.chartName); err != nil {
+		return ctrl.observeRelease("rollback", start)(nil, err)
+	}
+	return ctrl.observeRelease("rollback", start)(action.NewGet(actionConfig).Run(ctrl.chartName))
+}
+
+// newRollback returns a rollback client using the settings of the controller
+func (ctrl *Controller) newRollback() *action.Rollback {
+	rollbackClient := action.NewRollback(actionConfig)
+	rollbackClient.Wait = ctrl.wait
+	rollbackClient.Timeout = ctrl.timeout
+	rollbackClient.MaxHistory = ctrl.maxHistory
+	return rollbackClient
+}
+
+// observeRelease returns a function recording metrics and events for the result
+// of a helm operation started at the given time
+func (ctrl *Controller) observeRelease(operation string, start time.Time) func(*release.Release, error) (*release.Release, error) {
+	return func(rel *release.Release, err error) (*release.Release, error) {
+		result := metrics.Result(err)
+		metrics.HelmOperations.WithLabelValues(operation, result).Inc()
+		metrics.HelmOperationDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+		if err != nil {
+			ctrl.recordEvent(corev1.EventTypeWarning, defaults.ReleaseFailedReason, "helm %s of release %s failed: %s", operation, ctrl.chartName, err.Error())
+			return rel, err
+		}
+		if rel != nil {
+			metrics.ReleaseRevision.Set(float64(rel.Version))
+			metrics.ReleaseInfo.Reset()
+			metrics.ReleaseInfo.WithLabelValues(rel.Name, rel.Chart.Metadata.Version, rel.Chart.Metadata.AppVersion).Set(1)
+			reason := defaults.ReleaseUpgradedReason
+			switch operation {
+			case "install":
+				reason = defaults.ReleaseInstalledReason
+			case "rollback":
+				reason = defaults.ReleaseRolledBackReason
+			}
+			ctrl.recordEvent(corev1.EventTypeNormal, reason, "release %s revision %d deployed with chart version %s", rel.Name, rel.Version, rel.Chart.Metadata.Version)
+		}
+		return rel, err
+	}
+}
+
+// Render renders the release manifests client side from the current helm values,
+// exactly as they would be applied by UpgradeRelease
+func (ctrl *Controller) Render(ctx context.Context) (*release.Release, error) {
+	return ctrl.render(ctx, ctrl.snapshot())
+}
+
+// render renders the chart client side from the given state the same way it
+// would be rendered for install or upgrade, including post rendering
+func (ctrl *Controller) render(ctx context.Context, state *releaseState) (*release.Release, error) {
+	installClient := action.NewInstall(&action.Configuration{
+		Log: func(format string, v ...interface{}) {},
+	})
+	installClient.Namespace = ctrl.namespace
+	installClient.ReleaseName = ctrl.chartName
+	installClient.ClientOnly = true
+	installClient.DryRun = true
+	installClient.PostRenderer = newPostRenderer(state)
+	return installClient.RunWithContext(ctx, state.chart, state.values)
+}
+
+// snapshot returns the current state releases are made from
+func (ctrl *Controller) snapshot() *releaseState {
+	ctrl.stateMutex.Lock()
+	defer ctrl.stateMutex.Unlock()
+	return &releaseState{
+		chart:              ctrl.chart,
+		values:             ctrl.values(),
+		patches:            ctrl.patches,
+		canary:             ctrl.canary,
+		paused:             ctrl.paused,
+		maintenanceWindows: ctrl.maintenanceWindows,
+		rolloutFailed:      ctrl.failedGeneration != 0 && ctrl.failedGeneration == ctrl.generation,
+		nodeProfiles:       ctrl.nodeProfiles,
+		kaConfig:           ctrl.kaConfig,
+		complete:           len(ctrl.kaConfigValues) > 0 && len(ctrl.nodeConfigValues) > 0,
+	}
+}
+
+// values merges helm values in order of precedence, lowest first: values generated
+// from kubearmorconfig, user supplied values and node configuration values.
+// Chart defaults are applied by helm beneath all of them, except for lists merged
+// by key which are seeded with the chart default list. The caller holds stateMutex
+func (ctrl *Controller) values() map[string]interface{} {
+	base := map[string]interface{}{}
+	if ctrl.chart != nil {
+		base = chartListDefaults(ctrl.chart.Values, ctrl.listMergeKeys)
+	}
+	vals := mergeMapsWithListKeys(base, ctrl.kaConfigValues, "", ctrl.listMergeKeys)
+	vals = mergeMapsWithListKeys(vals, ctrl.userValues, "", ctrl.listMergeKeys)
+	return mergeMaps(vals, ctrl.nodeConfigValues)
+}
+
+// MergeValues merges helm values b over a, see mergeMaps. Lists listed in
+// spec.valuesListMerge are merged by key
+func MergeValues(a, b map[string]interface{}, spec operatorv1.KubeArmorConfigSpec) map[string]interface{} {
+	return mergeMapsWithListKeys(a, b, "", listMergeKeys(spec))
+}
+
+// mergeMaps merges b over a following helm value coalescing semantics: tables
+// are merged recursively, any other value including lists replaces the value in
+// a and null is kept so that helm removes the key from chart defaults
 // https://pkg.go.dev/helm.sh/helm/v3@v3.15.2/pkg/cli/values#Options.MergeValues
 func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
+	return mergeMapsWithListKeys(a, b, "", nil)
+}
+
+// mergeMapsWithListKeys works like mergeMaps but lists whose dot separated path
+// is present in listKeys are merged, items being identified by the value of the
+// given key field. Lists of scalar values are merged as a set
+func mergeMapsWithListKeys(a, b map[string]interface{}, prefix string, listKeys map[string]string) map[string]interface{} {
 	out := make(map[string]interface{}, len(a))
 	for k, v := range a {
 		out[k] = v
 	}
 	for k, v := range b {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
 		if v, ok := v.(map[string]interface{}); ok {
 			if bv, ok := out[k]; ok {
 				if bv, ok := bv.(map[string]interface{}); ok {
-					out[k] = mergeMaps(bv, v)
+					out[k] = mergeMapsWithListKeys(bv, v, path, listKeys)
+					continue
+				}
+			}
+		}
+		if key, ok := listKeys[path]; ok {
+			if list, ok := toList(v); ok {
+				if bv, ok := toList(out[k]); ok {
+					out[k] = mergeLists(bv, list, key)
 					continue
 				}
 			}
@@ -482,3 +935,80 @@ func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
 	}
 	return out
 }
+
+// mergeLists merges list b over list a, map items having the same value for
+// key are merged and scalar items already present in a are skipped
+func mergeLists(a, b []interface{}, key string) []interface{} {
+	out := append([]interface{}{}, a...)
+	for _, item := range b {
+		merged := false
+		for i, existing := range out {
+			itemMap, ok := item.(map[string]interface{})
+			existingMap, existingOk := existing.(map[string]interface{})
+			if ok && existingOk {
+				if id, ok := itemMap[key]; ok && id != nil && reflect.DeepEqual(id, existingMap[key]) {
+					out[i] = mergeMaps(existingMap, itemMap)
+					merged = true
+					break
+				}
+			} else if !ok && !existingOk && reflect.DeepEqual(item, existing) {
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// toList converts typed slices like []string or []map[string]interface{} to
+// []interface{}
+func toList(v interface{}) ([]interface{}, bool) {
+	if list, ok := v.([]interface{}); ok {
+		return list, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}
+
+// chartListDefaults returns a values tree holding only the chart default lists
+// present at the given paths
+func chartListDefaults(chartValues map[string]interface{}, listKeys map[string]string) map[string]interface{} {
+	out := map[string]interface{}{}
+	for path := range listKeys {
+		keys := strings.Split(path, ".")
+		var val interface{} = chartValues
+		for _, k := range keys {
+			m, ok := val.(map[string]interface{})
+			if !ok {
+				val = nil
+				break
+			}
+			val = m[k]
+		}
+		list, ok := toList(val)
+		if !ok {
+			continue
+		}
+		node := out
+		for _, k := range keys[:len(keys)-1] {
+			child, ok := node[k].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[k] = child
+			}
+			node = child
+		}
+		node[keys[len(keys)-1]] = list
+	}
+	return out
+}
@@ -2,8 +2,10 @@ package helm
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/action"
 
 	"github.com/Masterminds/semver/v3"
 )
@@ -80,3 +82,155 @@ func TestMergeMaps(t *testing.T) {
 	assert.Equal(t, "stable", mergedMap["image"].(map[string]interface{})["tag"])
 	assert.Equal(t, "kubearmor/kubearmor", mergedMap["image"].(map[string]interface{})["repository"])
 }
+
+func TestValuesPrecedence(t *testing.T) {
+	ctrl := Controller{
+		kaConfigValues: map[string]interface{}{
+			"kubearmorConfigMap": map[string]interface{}{
+				"defaultFilePosture": "block",
+				"visibility":         "process",
+			},
+		},
+		nodeConfigValues: map[string]interface{}{
+			"nodes": []map[string]interface{}{},
+		},
+	}
+	ctrl.UpdateUserHelmValues(map[string]interface{}{
+		"kubearmorConfigMap": map[string]interface{}{
+			"visibility": "process,file",
+		},
+		"kubearmorController": map[string]interface{}{
+			"replicas": 2,
+		},
+		// node configuration is managed by the operator
+		"nodes": nil,
+	})
+
+	vals := ctrl.values()
+	configMap := vals["kubearmorConfigMap"].(map[string]interface{})
+	assert.Equal(t, "block", configMap["defaultFilePosture"])
+	assert.Equal(t, "process,file", configMap["visibility"])
+	assert.Equal(t, 2, vals["kubearmorController"].(map[string]interface{})["replicas"])
+	assert.NotNil(t, vals["nodes"])
+}
+
+func TestMergeMapsNull(t *testing.T) {
+	originalMap := map[string]interface{}{
+		"kubearmorRelay": map[string]interface{}{
+			"enabled": true,
+			"image": map[string]interface{}{
+				"tag": "latest",
+			},
+		},
+	}
+
+	updatedMap := map[string]interface{}{
+		"kubearmorRelay": map[string]interface{}{
+			"image": nil,
+		},
+	}
+
+	// null is kept so that helm removes the key from chart defaults
+	mergedMap := mergeMaps(originalMap, updatedMap)
+	relay := mergedMap["kubearmorRelay"].(map[string]interface{})
+	value, ok := relay["image"]
+	assert.True(t, ok)
+	assert.Nil(t, value)
+	assert.Equal(t, true, relay["enabled"])
+	// inputs are not modified
+	assert.NotNil(t, originalMap["kubearmorRelay"].(map[string]interface{})["image"])
+}
+
+func TestMergeMapsWithListKeys(t *testing.T) {
+	originalMap := map[string]interface{}{
+		"volumes": map[string]interface{}{
+			"common": []interface{}{
+				map[string]interface{}{"name": "sys-kernel-debug-path", "hostPath": map[string]interface{}{"path": "/sys/kernel/debug"}},
+			},
+		},
+		"kubearmorRelay": map[string]interface{}{
+			"tls": map[string]interface{}{
+				"extraDnsNames": []interface{}{"localhost"},
+			},
+		},
+	}
+
+	updatedMap := map[string]interface{}{
+		"volumes": map[string]interface{}{
+			"common": []map[string]interface{}{
+				{"name": "sys-kernel-debug-path", "hostPath": map[string]interface{}{"type": "Directory"}},
+				{"name": "extra", "emptyDir": map[string]interface{}{}},
+			},
+		},
+		"kubearmorRelay": map[string]interface{}{
+			"tls": map[string]interface{}{
+				"extraDnsNames": []string{"localhost", "relay.example.com"},
+			},
+		},
+	}
+
+	// lists are replaced by default
+	mergedMap := mergeMaps(originalMap, updatedMap)
+	assert.Equal(t, updatedMap["volumes"], mergedMap["volumes"])
+
+	mergedMap = mergeMapsWithListKeys(originalMap, updatedMap, "", map[string]string{
+		"volumes.common":                   "name",
+		"kubearmorRelay.tls.extraDnsNames": "name",
+	})
+	volumes := mergedMap["volumes"].(map[string]interface{})["common"].([]interface{})
+	assert.Equal(t, 2, len(volumes))
+	assert.Equal(t, map[string]interface{}{"path": "/sys/kernel/debug", "type": "Directory"}, volumes[0].(map[string]interface{})["hostPath"])
+	assert.Equal(t, "extra", volumes[1].(map[string]interface{})["name"])
+
+	dnsNames := mergedMap["kubearmorRelay"].(map[string]interface{})["tls"].(map[string]interface{})["extraDnsNames"]
+	assert.Equal(t, []interface{}{"localhost", "relay.example.com"}, dnsNames)
+}
+
+func TestChartListDefaults(t *testing.T) {
+	chartValues := map[string]interface{}{
+		"volumes": map[string]interface{}{
+			"common": []interface{}{
+				map[string]interface{}{"name": "sys-kernel-debug-path"},
+			},
+			"enforcer": map[string]interface{}{},
+		},
+	}
+	defaults := chartListDefaults(chartValues, map[string]string{
+		"volumes.common":  "name",
+		"volumes.missing": "name",
+	})
+	assert.Equal(t, map[string]interface{}{
+		"volumes": map[string]interface{}{
+			"common": []interface{}{
+				map[string]interface{}{"name": "sys-kernel-debug-path"},
+			},
+		},
+	}, defaults)
+}
+
+func TestOperationCheck(t *testing.T) {
+	ctrl := &Controller{chartName: "kubearmor", timeout: time.Minute}
+	assert.NoError(t, ctrl.OperationCheck(nil))
+
+	unlock := ctrl.lock("preinstall")
+	assert.NoError(t, ctrl.OperationCheck(nil))
+	ctrl.operation.Store(&helmOperation{name: "preinstall", started: time.Now().Add(-3 * time.Minute)})
+	assert.ErrorContains(t, ctrl.OperationCheck(nil), "helm preinstall of release kubearmor is stuck for 3m0s")
+
+	unlock()
+	assert.NoError(t, ctrl.OperationCheck(nil))
+}
+
+func TestStorageCheck(t *testing.T) {
+	previous := actionConfig
+	defer func() { actionConfig = previous }()
+	actionConfig = testActionConfig(t)
+	ctrl := &Controller{chartName: "kubearmor"}
+	now := time.Now()
+	assert.NoError(t, ctrl.checkStorage(now))
+
+	// the storage is not checked again until the interval passed
+	actionConfig = &action.Configuration{}
+	assert.NoError(t, ctrl.checkStorage(now.Add(storageCheckInterval/2)))
+	assert.Error(t, ctrl.checkStorage(now.Add(storageCheckInterval)))
+}
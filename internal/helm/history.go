@@ -0,0 +1,97 @@
+package helm
+
+import (
+	"errors"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// initActionConfig initializes the helm action config with the given storage
+// driver, limiting the release history to maxHistory revisions
+func initActionConfig(namespace, storageDriver string, maxHistory int) (err error) {
+	if storageDriver == "" {
+		storageDriver = "secret"
+	}
+	// the sql driver panics when it fails to connect
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to initialize %s storage driver: %v", storageDriver, r)
+		}
+	}()
+	if err := actionConfig.Init(settings.RESTClientGetter(), namespace, storageDriver, debugLog); err != nil {
+		return err
+	}
+	actionConfig.Releases.MaxHistory = maxHistory
+	return nil
+}
+
+// pruneHistory deletes the oldest revisions of the release beyond maxHistory,
+// keeping the deployed revision. It returns the number of deleted revisions
+func pruneHistory(releases *storage.Storage, name string, maxHistory int) (int, error) {
+	if maxHistory <= 0 {
+		return 0, nil
+	}
+	history, err := releases.History(name)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(history) <= maxHistory {
+		return 0, nil
+	}
+	releaseutil.SortByRevision(history)
+	deployed, err := releases.Deployed(name)
+	if err != nil && !errors.Is(err, driver.ErrNoDeployedReleases) {
+		return 0, err
+	}
+
+	pruned := 0
+	var errs []error
+	for _, rel := range history {
+		if len(history)-pruned <= maxHistory {
+			break
+		}
+		if deployed != nil && rel.Version == deployed.Version {
+			continue
+		}
+		if _, err := releases.Delete(name, rel.Version); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pruned++
+	}
+	return pruned, errors.Join(errs...)
+}
+
+// recoverPendingRelease recovers the latest revision of the release left
+// pending by an interrupted operation, e.g. when the operator restarted during
+// an upgrade, as helm refuses to upgrade pending releases. It is rolled back to
+// the previous revision, a pending install has none and is marked failed which
+// upgrades proceed over. It returns the latest revision after the recovery
+func recoverPendingRelease(cfg *action.Configuration, rollback *action.Rollback, rel *release.Release) (*release.Release, error) {
+	history, err := cfg.Releases.History(rel.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) < 2 {
+		rel.Info.Status = release.StatusFailed
+		rel.Info.Description = "Install interrupted"
+		if err := cfg.Releases.Update(rel); err != nil {
+			return nil, err
+		}
+		return rel, nil
+	}
+	// version 0 is the previous revision
+	rollback.Version = 0
+	if err := rollback.Run(rel.Name); err != nil {
+		return nil, err
+	}
+	return action.NewGet(cfg).Run(rel.Name)
+}
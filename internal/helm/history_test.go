@@ -0,0 +1,74 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+func TestPruneHistory(t *testing.T) {
+	releases := storage.Init(driver.NewMemory())
+	for version := 1; version <= 6; version++ {
+		status := release.StatusSuperseded
+		switch version {
+		case 2:
+			// a rollback to revision 2 is still deployed
+			status = release.StatusDeployed
+		case 5, 6:
+			status = release.StatusFailed
+		}
+		assert.NoError(t, releases.Create(&release.Release{
+			Name:      "kubearmor",
+			Namespace: "kubearmor",
+			Version:   version,
+			Info:      &release.Info{Status: status},
+		}))
+	}
+
+	pruned, err := pruneHistory(releases, "kubearmor", 0)
+	assert.NoError(t, err)
+	assert.Zero(t, pruned)
+
+	pruned, err = pruneHistory(releases, "kubearmor", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, pruned)
+	history, err := releases.History("kubearmor")
+	assert.NoError(t, err)
+	versions := []int{}
+	for _, rel := range history {
+		versions = append(versions, rel.Version)
+	}
+	assert.ElementsMatch(t, []int{2, 5, 6}, versions)
+
+	pruned, err = pruneHistory(releases, "missing", 3)
+	assert.NoError(t, err)
+	assert.Zero(t, pruned)
+}
+
+func TestRecoverPendingRelease(t *testing.T) {
+	// an interrupted upgrade is rolled back
+	deployed := testRevision("kubearmor", 1, "v1.3.8")
+	deployed.Info.Status = release.StatusSuperseded
+	pending := testRevision("kubearmor", 2, "v1.3.8")
+	pending.Info.Status = release.StatusPendingUpgrade
+	cfg := testActionConfig(t, deployed, pending)
+	rel, err := recoverPendingRelease(cfg, action.NewRollback(cfg), pending)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, rel.Version)
+	assert.Equal(t, release.StatusDeployed, rel.Info.Status)
+
+	// an interrupted install has nothing to roll back to
+	pending = testRevision("kubearmor", 1, "v1.3.8")
+	pending.Info.Status = release.StatusPendingInstall
+	cfg = testActionConfig(t, pending)
+	rel, err = recoverPendingRelease(cfg, action.NewRollback(cfg), pending)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rel.Version)
+	latest, err := cfg.Releases.Last("kubearmor")
+	assert.NoError(t, err)
+	assert.Equal(t, release.StatusFailed, latest.Info.Status)
+}
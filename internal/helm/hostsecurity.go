@@ -0,0 +1,72 @@
+package helm
+
+import (
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+)
+
+// hostConfigMapKeys are the kubearmorConfigMap values of the default host
+// postures and visibility, charts up to v1.3.8 don't render them
+var hostConfigMapKeys = []string{
+	"hostDefaultFilePosture",
+	"hostDefaultCapabilitiesPosture",
+	"hostDefaultNetworkPosture",
+	"hostVisibility",
+}
+
+// configMapTarget selects the KubeArmor configmap
+var configMapTarget = operatorv1.PatchTarget{Kind: "ConfigMap", LabelSelector: "kubearmor-app=kubearmor-configmap"}
+
+// missingConfigMapData returns the host kubearmorConfigMap values the chart
+// templates don't render
+func missingConfigMapData(c *chart.Chart, values map[string]interface{}) map[string]string {
+	configMap, ok := values["kubearmorConfigMap"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	data := map[string]string{}
+	for _, key := range hostConfigMapKeys {
+		value, ok := configMap[key]
+		if !ok || value == nil || rendersValue(c, ".Values.kubearmorConfigMap."+key) {
+			continue
+		}
+		data[key] = fmt.Sprint(value)
+	}
+	return data
+}
+
+// addConfigMapData adds data to a rendered KubeArmor configmap, keys rendered
+// by the chart are kept. Other resources are returned unchanged
+func addConfigMapData(doc []byte, data map[string]string) ([]byte, error) {
+	if len(data) == 0 {
+		return doc, nil
+	}
+	ok, err := targetMatches(doc, configMapTarget)
+	if err != nil || !ok {
+		return doc, err
+	}
+	cm := unstructured.Unstructured{}
+	if err := cm.UnmarshalJSON(doc); err != nil {
+		return nil, err
+	}
+	current, _, err := unstructured.NestedStringMap(cm.Object, "data")
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		current = map[string]string{}
+	}
+	for key, value := range data {
+		if _, ok := current[key]; !ok {
+			current[key] = value
+		}
+	}
+	if err := unstructured.SetNestedStringMap(cm.Object, current, "data"); err != nil {
+		return nil, err
+	}
+	return cm.MarshalJSON()
+}
@@ -0,0 +1,51 @@
+package helm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/chart"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+)
+
+func TestHostSecurity(t *testing.T) {
+	spec := operatorv1.KubeArmorConfigSpec{
+		HostSecurity: operatorv1.HostSecurity{
+			Enable:             true,
+			DefaultFilePosture: "block",
+			Visibility:         "process,file",
+		},
+	}
+	assert.Equal(t, []string{
+		"-enableKubeArmorHostPolicy=true",
+		"-hostDefaultFilePosture=block",
+		"-hostVisibility=process,file",
+	}, DaemonArgs(spec))
+
+	ctrl := &Controller{}
+	ctrl.UpdateHelmValuesFromKubeArmorConfig(&operatorv1.KubeArmorConfig{Spec: spec})
+	values := ctrl.values()
+	configMap := values["kubearmorConfigMap"].(map[string]interface{})
+	assert.Equal(t, "block", configMap["hostDefaultFilePosture"])
+	assert.Equal(t, "process,file", configMap["hostVisibility"])
+	assert.NotContains(t, configMap, "hostDefaultNetworkPosture")
+
+	// the configmap template renders hostVisibility only
+	c := &chart.Chart{Templates: []*chart.File{{
+		Name: "templates/configmap.yaml",
+		Data: []byte("hostVisibility: {{ .Values.kubearmorConfigMap.hostVisibility | quote }}"),
+	}}}
+	assert.Equal(t, map[string]string{"hostDefaultFilePosture": "block"}, missingConfigMapData(c, values))
+
+	renderer := newPostRenderer(&releaseState{chart: c, values: values})
+	out, err := renderer.Run(bytes.NewBufferString(manifests))
+	assert.NoError(t, err)
+	docs := strings.Split(out.String(), "---\n")[1:]
+	assert.Len(t, docs, 2)
+	assert.Contains(t, docs[0], "hostDefaultFilePosture: block\n")
+	assert.Contains(t, docs[0], "visibility: process\n")
+	assert.Contains(t, docs[1], "- -enableKubeArmorHostPolicy=true\n        - -hostDefaultFilePosture=block\n")
+}
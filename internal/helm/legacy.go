@@ -0,0 +1,234 @@
+package helm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metaerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+)
+
+// helm ownership metadata, helm takes over existing resources carrying it
+// instead of failing the install
+const (
+	helmManagedByLabel             = "app.kubernetes.io/managed-by"
+	helmReleaseNameAnnotation      = "meta.helm.sh/release-name"
+	helmReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+// legacyControllerName is the kubearmor controller deployment of legacy operators
+const legacyControllerName = "kubearmor-controller"
+
+// chartDaemonSet returns the selector and name the chart renders for the node
+// configuration a daemonset is scheduled by, see "daemonset.template" of the
+// chart. Node configurations are not known when migrating, so they are taken
+// from the kubearmor.io node selector of the existing daemonset
+func chartDaemonSet(ds *unstructured.Unstructured) (map[string]interface{}, string, error) {
+	nodeSelector, _, err := unstructured.NestedStringMap(ds.Object, "spec", "template", "spec", "nodeSelector")
+	if err != nil {
+		return nil, "", err
+	}
+	matchLabels := map[string]interface{}{"kubearmor-app": "kubearmor"}
+	for key, value := range nodeSelector {
+		if strings.HasPrefix(key, "kubearmor.io/") {
+			matchLabels[key] = value
+		}
+	}
+	arch, ok := nodeSelector[defaults.ArchLabel]
+	if !ok {
+		return nil, "", fmt.Errorf("node selector has no %s label", defaults.ArchLabel)
+	}
+	matchLabels[defaults.ArchLabel] = arch
+	for _, label := range []string{defaults.EnforcerLabel, defaults.RuntimeLabel, defaults.SocketLabel} {
+		if _, ok := nodeSelector[label]; !ok {
+			return nil, "", fmt.Errorf("node selector has no %s label", label)
+		}
+	}
+	name := fmt.Sprintf("kubearmor-%s-%s-%s", nodeSelector[defaults.EnforcerLabel], nodeSelector[defaults.RuntimeLabel],
+		defaults.ShortSHA(nodeSelector[defaults.SocketLabel]))
+	return map[string]interface{}{"matchLabels": matchLabels}, name, nil
+}
+
+var (
+	daemonSetsGVR  = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}
+	deploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+)
+
+// legacyResource is a resource of the previous installation
+type legacyResource struct {
+	client   dynamic.ResourceInterface
+	existing *unstructured.Unstructured
+	// rendered is the resource rendered by the release, nil if not rendered
+	rendered *unstructured.Unstructured
+}
+
+// migrateLegacyResources moves the KubeArmor resources deployed by operators
+// older than v1.3.8 under the release. Helm refuses to install over resources
+// it does not manage, so they are either adopted by adding helm ownership
+// metadata, which keeps KubeArmor enforcing during the migration, or deleted
+// if adoption is disabled or their immutable fields differ from the release
+func migrateLegacyResources(ctx context.Context, mc *MigrationContext) error {
+	resources, err := legacyResources(ctx, mc)
+	if err != nil {
+		return err
+	}
+
+	adopted, deleted := 0, 0
+	for _, r := range resources {
+		kind, name := r.existing.GetKind(), r.existing.GetName()
+		if owner := r.existing.GetAnnotations()[helmReleaseNameAnnotation]; owner != "" && owner != mc.ReleaseName {
+			// helm reports the conflict on install, it is not ours to delete
+			log.Info("skipping resource managed by another release", "kind", kind, "name", name, "release", owner)
+			continue
+		}
+		if mc.Adopt {
+			reason := incompatibility(r)
+			if reason == "" {
+				if err := adopt(ctx, r, mc.ReleaseName, mc.Namespace); err != nil {
+					return fmt.Errorf("failed to adopt %s %s: %s", kind, name, err.Error())
+				}
+				log.Info("adopted resource", "kind", kind, "name", name)
+				adopted++
+				continue
+			}
+			log.Info("resource can't be adopted, deleting it", "kind", kind, "name", name, "reason", reason)
+		}
+		err := r.client.Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !metaerrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s %s: %s", kind, name, err.Error())
+		}
+		log.Info("deleted resource", "kind", kind, "name", name)
+		deleted++
+	}
+	mc.Event(defaults.PreinstallCleanupReason, "adopted %d and deleted %d resources of the previous KubeArmor installation", adopted, deleted)
+	return nil
+}
+
+// legacyResources returns the existing resources the release renders, and the
+// daemonsets and controller deployment of legacy operators. Daemonsets are named
+// after node configurations which may not be known when migrating
+func legacyResources(ctx context.Context, mc *MigrationContext) ([]legacyResource, error) {
+	rel, err := mc.Render(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering release: %s", err.Error())
+	}
+	resources := []legacyResource{}
+	seen := map[string]bool{}
+	for _, manifest := range releaseutil.SplitManifests(rel.Manifest) {
+		cleanManifest := removeManifestHeader(manifest)
+		if strings.TrimSpace(cleanManifest) == "" {
+			continue
+		}
+		rendered := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(cleanManifest), &rendered.Object); err != nil {
+			return nil, fmt.Errorf("error decoding manifest: %v", err)
+		}
+		mapping, err := mc.Mapper.RESTMapping(rendered.GroupVersionKind().GroupKind(), rendered.GroupVersionKind().Version)
+		if err != nil {
+			log.Error(err, "failed to get mapping to kind", "kind", rendered.GetKind())
+			continue
+		}
+		var client dynamic.ResourceInterface = mc.Dynamic.Resource(mapping.Resource)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			client = mc.Dynamic.Resource(mapping.Resource).Namespace(mc.Namespace)
+		}
+		existing, err := client.Get(ctx, rendered.GetName(), metav1.GetOptions{})
+		if metaerrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get %s %s: %s", rendered.GetKind(), rendered.GetName(), err.Error())
+		}
+		seen[rendered.GetKind()+"/"+rendered.GetName()] = true
+		resources = append(resources, legacyResource{client: client, existing: existing, rendered: rendered})
+	}
+
+	daemonSetClient := mc.Dynamic.Resource(daemonSetsGVR).Namespace(mc.Namespace)
+	daemonSets, err := daemonSetClient.List(ctx, metav1.ListOptions{LabelSelector: defaults.KubeArmorDaemonSetSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list kubearmor daemonsets: %s", err.Error())
+	}
+	for i := range daemonSets.Items {
+		ds := &daemonSets.Items[i]
+		if seen["DaemonSet/"+ds.GetName()] {
+			continue
+		}
+		// list items carry no type meta
+		ds.SetKind("DaemonSet")
+		resources = append(resources, legacyResource{client: daemonSetClient, existing: ds})
+	}
+
+	if !seen["Deployment/"+legacyControllerName] {
+		deployClient := mc.Dynamic.Resource(deploymentsGVR).Namespace(mc.Namespace)
+		deploy, err := deployClient.Get(ctx, legacyControllerName, metav1.GetOptions{})
+		if err != nil && !metaerrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get deployment %s: %s", legacyControllerName, err.Error())
+		}
+		if err == nil {
+			resources = append(resources, legacyResource{client: deployClient, existing: deploy})
+		}
+	}
+	return resources, nil
+}
+
+// incompatibility returns why the release can't take over the resource in
+// place, empty if it can
+func incompatibility(r legacyResource) string {
+	kind := r.existing.GetKind()
+	switch kind {
+	case "Job":
+		return "job templates are immutable"
+	case "DaemonSet", "Deployment", "StatefulSet", "ReplicaSet":
+		var want interface{}
+		switch {
+		case r.rendered != nil:
+			want, _, _ = unstructured.NestedFieldNoCopy(r.rendered.Object, "spec", "selector")
+		case kind == "DaemonSet":
+			selector, name, err := chartDaemonSet(r.existing)
+			if err != nil {
+				return err.Error()
+			}
+			if name != r.existing.GetName() {
+				// it would be left running next to the daemonset of the release
+				return fmt.Sprintf("the release names the daemonset %s", name)
+			}
+			want = selector
+		default:
+			return "not rendered by the release"
+		}
+		have, _, _ := unstructured.NestedFieldNoCopy(r.existing.Object, "spec", "selector")
+		if !equality.Semantic.DeepEqual(want, have) {
+			return "selector differs from the release"
+		}
+	}
+	return ""
+}
+
+// adopt adds helm ownership metadata of the release to the resource
+func adopt(ctx context.Context, r legacyResource, releaseName, namespace string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{helmManagedByLabel: "Helm"},
+			"annotations": map[string]string{
+				helmReleaseNameAnnotation:      releaseName,
+				helmReleaseNamespaceAnnotation: namespace,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.client.Patch(ctx, r.existing.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,228 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	metaerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+)
+
+const legacyManifest = `---
+# Source: kubearmor/templates/daemonset.yaml
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: kubearmor-bpf-containerd-98c2c
+  namespace: kubearmor
+spec:
+  selector:
+    matchLabels:
+      kubearmor-app: kubearmor
+---
+# Source: kubearmor/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: kubearmor-controller
+  namespace: kubearmor
+spec:
+  selector:
+    matchLabels:
+      kubearmor-app: kubearmor-controller
+---
+# Source: kubearmor/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: kubearmor-config
+  namespace: kubearmor
+`
+
+func legacyObject(apiVersion, kind, name string, selector map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "kubearmor",
+		},
+	}}
+	if selector != nil {
+		u.Object["spec"] = map[string]interface{}{"selector": map[string]interface{}{"matchLabels": selector}}
+	}
+	if kind == "DaemonSet" {
+		u.SetLabels(map[string]string{"kubearmor-app": "kubearmor"})
+	}
+	return u
+}
+
+// nodeConfigLabels returns the node labels of a node configuration
+func nodeConfigLabels(runtime, socket string) map[string]string {
+	return map[string]string{
+		defaults.EnforcerLabel:   "apparmor",
+		defaults.RuntimeLabel:    runtime,
+		defaults.SocketLabel:     socket,
+		defaults.BTFLabel:        "yes",
+		defaults.ApparmorFsLabel: "yes",
+		defaults.SeccompLabel:    "yes",
+	}
+}
+
+// nodeConfigDaemonSet returns the daemonset of a node configuration shaped
+// like the chart renders it or, if legacy, like operators older than v1.3.8
+// created it
+func nodeConfigDaemonSet(nodeLabels map[string]string, legacy bool) *unstructured.Unstructured {
+	nodeSelector := map[string]interface{}{defaults.OsLabel: "linux"}
+	matchLabels := map[string]interface{}{"kubearmor-app": "kubearmor"}
+	for key, value := range nodeLabels {
+		nodeSelector[key] = value
+		matchLabels[key] = value
+	}
+	if legacy {
+		// legacy operators select the os instead of the architecture
+		matchLabels[defaults.OsLabel] = "linux"
+	} else {
+		nodeSelector[defaults.ArchLabel] = "amd64"
+		matchLabels[defaults.ArchLabel] = "amd64"
+	}
+	name := fmt.Sprintf("kubearmor-%s-%s-%s", nodeLabels[defaults.EnforcerLabel], nodeLabels[defaults.RuntimeLabel],
+		defaults.ShortSHA(nodeLabels[defaults.SocketLabel]))
+	ds := legacyObject("apps/v1", "DaemonSet", name, nil)
+	ds.Object["spec"] = map[string]interface{}{
+		"selector": map[string]interface{}{"matchLabels": matchLabels},
+		"template": map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": matchLabels},
+			"spec":     map[string]interface{}{"nodeSelector": nodeSelector},
+		},
+	}
+	return ds
+}
+
+var (
+	// not rendered as no node configuration is known when migrating
+	chartDaemonSetObject  = nodeConfigDaemonSet(nodeConfigLabels("cri-o", "var_run_crio_crio.sock"), false)
+	legacyDaemonSetObject = nodeConfigDaemonSet(nodeConfigLabels("containerd", "run_containerd_containerd.sock"), true)
+)
+
+func legacyMigrationContext(adopt bool) *MigrationContext {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		// rendered and compatible
+		legacyObject("apps/v1", "DaemonSet", "kubearmor-bpf-containerd-98c2c", map[string]interface{}{"kubearmor-app": "kubearmor"}),
+		// of a node configuration, shaped like the chart renders it
+		chartDaemonSetObject.DeepCopy(),
+		// created by a legacy operator, its selector differs from the chart
+		legacyDaemonSetObject.DeepCopy(),
+		// selecting all kubearmor pods, the chart would render another daemonset
+		legacyObject("apps/v1", "DaemonSet", "kubearmor-apparmor-cri-o-6aa4e", map[string]interface{}{"kubearmor-app": "kubearmor"}),
+		// the chart renders a different selector
+		legacyObject("apps/v1", "Deployment", "kubearmor-controller", map[string]interface{}{"app": "kubearmor-controller"}),
+		legacyObject("v1", "ConfigMap", "kubearmor-config", nil),
+	)
+	return &MigrationContext{
+		Namespace:   "kubearmor",
+		ReleaseName: "kubearmor",
+		Render: func(context.Context) (*release.Release, error) {
+			return &release.Release{Manifest: legacyManifest}, nil
+		},
+		Dynamic: dynamicClient,
+		Mapper:  mapper,
+		Event:   func(string, string, ...interface{}) {},
+		Adopt:   adopt,
+	}
+}
+
+func TestMigrateLegacyResources(t *testing.T) {
+	ctx := context.Background()
+	configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+	mc := legacyMigrationContext(true)
+	assert.NoError(t, migrateLegacyResources(ctx, mc))
+
+	for _, name := range []string{"kubearmor-bpf-containerd-98c2c", chartDaemonSetObject.GetName()} {
+		ds, err := mc.Dynamic.Resource(daemonSetsGVR).Namespace("kubearmor").Get(ctx, name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "Helm", ds.GetLabels()[helmManagedByLabel])
+		assert.Equal(t, "kubearmor", ds.GetLabels()["kubearmor-app"])
+		assert.Equal(t, map[string]string{
+			helmReleaseNameAnnotation:      "kubearmor",
+			helmReleaseNamespaceAnnotation: "kubearmor",
+		}, ds.GetAnnotations())
+	}
+	cm, err := mc.Dynamic.Resource(configMaps).Namespace("kubearmor").Get(ctx, "kubearmor-config", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "kubearmor", cm.GetAnnotations()[helmReleaseNameAnnotation])
+	_, err = mc.Dynamic.Resource(deploymentsGVR).Namespace("kubearmor").Get(ctx, "kubearmor-controller", metav1.GetOptions{})
+	assert.True(t, metaerrors.IsNotFound(err), "incompatible deployment is deleted")
+	for _, name := range []string{legacyDaemonSetObject.GetName(), "kubearmor-apparmor-cri-o-6aa4e"} {
+		_, err = mc.Dynamic.Resource(daemonSetsGVR).Namespace("kubearmor").Get(ctx, name, metav1.GetOptions{})
+		assert.True(t, metaerrors.IsNotFound(err), "incompatible daemonset %s is deleted", name)
+	}
+
+	// adoption is idempotent
+	assert.NoError(t, migrateLegacyResources(ctx, mc))
+
+	mc = legacyMigrationContext(false)
+	assert.NoError(t, migrateLegacyResources(ctx, mc))
+	daemonSets, err := mc.Dynamic.Resource(daemonSetsGVR).Namespace("kubearmor").List(ctx, metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, daemonSets.Items)
+	_, err = mc.Dynamic.Resource(configMaps).Namespace("kubearmor").Get(ctx, "kubearmor-config", metav1.GetOptions{})
+	assert.True(t, metaerrors.IsNotFound(err))
+}
+
+func TestChartDaemonSet(t *testing.T) {
+	chart, err := getEmbeddedHelmChart("kubearmor", "v1.3.8")
+	assert.NoError(t, err)
+	ctrl := Controller{chartName: "kubearmor", namespace: "kubearmor", chart: chart}
+	ctrl.UpdateNodeConfigHelmValues([]map[string]interface{}{{
+		"config": map[string]interface{}{
+			"enforcer":   "apparmor",
+			"runtime":    "cri-o",
+			"socket":     "var_run_crio_crio.sock",
+			"arch":       "amd64",
+			"btf":        "yes",
+			"apparmorfs": "yes",
+			"seccomp":    "yes",
+		},
+	}})
+	rel, err := ctrl.render(context.Background(), ctrl.snapshot())
+	assert.NoError(t, err)
+
+	var rendered *unstructured.Unstructured
+	for _, manifest := range releaseutil.SplitManifests(rel.Manifest) {
+		u := &unstructured.Unstructured{}
+		assert.NoError(t, yaml.Unmarshal([]byte(removeManifestHeader(manifest)), &u.Object))
+		if u.GetKind() == "DaemonSet" && u.GetLabels()["kubearmor-app"] == "kubearmor" {
+			rendered = u
+		}
+	}
+	assert.NotNil(t, rendered)
+
+	// the selector and name of the rendered daemonset follow from its node selector
+	selector, name, err := chartDaemonSet(rendered)
+	assert.NoError(t, err)
+	want, _, _ := unstructured.NestedFieldNoCopy(rendered.Object, "spec", "selector")
+	assert.Equal(t, want, selector)
+	assert.Equal(t, rendered.GetName(), name)
+	assert.Empty(t, incompatibility(legacyResource{existing: chartDaemonSetObject}))
+
+	assert.Contains(t, incompatibility(legacyResource{existing: legacyDaemonSetObject}), "no kubernetes.io/arch label")
+}
@@ -0,0 +1,192 @@
+package helm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	semver "github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
+	metaerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MigrationsConfigMap is the configmap applied migrations are recorded in
+const MigrationsConfigMap = "kubearmor-operator-migrations"
+
+// LegacyReleaseName is the release of the operator chart, operators older than
+// v1.3.8 deployed KubeArmor resources directly instead of through a release
+const LegacyReleaseName = "kubearmor-operator"
+
+// Migration prepares an existing installation for a chart version whose
+// resources are incompatible with it. It is applied once, before the release
+// is installed or upgraded, when the installed chart version satisfies From and
+// the target chart version satisfies To
+type Migration struct {
+	// Name identifies the migration in the record of applied migrations
+	Name string
+	// From is the semver constraint on the installed chart version
+	From string
+	// To is the semver constraint on the target chart version
+	To string
+	// Apply performs the migration, it must be idempotent as it is retried
+	// until it succeeds
+	Apply func(ctx context.Context, mc *MigrationContext) error
+}
+
+// MigrationContext provides migrations with the installation being migrated
+type MigrationContext struct {
+	Namespace string
+	// ReleaseName of the KubeArmor release
+	ReleaseName string
+	// From is the installed chart version
+	From *semver.Version
+	// To is the target chart version
+	To *semver.Version
+	// Render renders the target release client side
+	Render  func(ctx context.Context) (*release.Release, error)
+	Dynamic dynamic.Interface
+	Mapper  meta.RESTMapper
+	// Event records a normal event on the operator
+	Event func(reason, messageFmt string, args ...interface{})
+	// Adopt existing resources by the release rather than deleting them
+	Adopt bool
+}
+
+// Migrations is the registry of migrations, applied in order
+var Migrations = []Migration{
+	{
+		Name:  "legacy-resources",
+		From:  "< 1.3.8",
+		To:    ">= 1.3.8",
+		Apply: migrateLegacyResources,
+	},
+}
+
+// Migrator applies the migrations required by an installation
+type Migrator struct {
+	Migrations   []Migration
+	ActionConfig *action.Configuration
+	Clientset    kubernetes.Interface
+	Namespace    string
+	// ReleaseName of the KubeArmor release
+	ReleaseName string
+	// LegacyReleaseName is the release whose chart version is the installed
+	// version if there is no KubeArmor release, disabled if empty
+	LegacyReleaseName string
+}
+
+// InstalledVersion returns the chart version of the latest KubeArmor release
+// revision, or if it is not installed the lowest chart version in the history
+// of the legacy release. It returns nil if KubeArmor is not installed
+func (m *Migrator) InstalledVersion() (*semver.Version, error) {
+	rel, err := action.NewGet(m.ActionConfig).Run(m.ReleaseName)
+	if err == nil {
+		return semver.NewVersion(rel.Chart.Metadata.Version)
+	}
+	if !errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, err
+	}
+	if m.LegacyReleaseName == "" {
+		return nil, nil
+	}
+	history, err := action.NewHistory(m.ActionConfig).Run(m.LegacyReleaseName)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var installed *semver.Version
+	for _, rel := range history {
+		ver, err := semver.NewVersion(rel.Chart.Metadata.Version)
+		if err != nil {
+			log.Error(err, "ignoring release revision with an invalid chart version",
+				"release", rel.Name, "revision", rel.Version)
+			continue
+		}
+		if installed == nil || ver.LessThan(installed) {
+			installed = ver
+		}
+	}
+	return installed, nil
+}
+
+// Run applies the migrations required to move the installation to the target
+// chart version that have not been applied yet, and records them. It stops at
+// the first failing migration and returns the names of the applied ones
+func (m *Migrator) Run(ctx context.Context, to string, mc *MigrationContext) ([]string, error) {
+	var err error
+	if mc.To, err = semver.NewVersion(to); err != nil {
+		return nil, fmt.Errorf("invalid target chart version %q: %s", to, err.Error())
+	}
+	if mc.From, err = m.InstalledVersion(); err != nil {
+		return nil, fmt.Errorf("unable to determine the installed chart version: %s", err.Error())
+	}
+	if mc.From == nil {
+		// fresh installation, nothing to migrate
+		return nil, nil
+	}
+
+	configMaps := m.Clientset.CoreV1().ConfigMaps(m.Namespace)
+	record, err := configMaps.Get(ctx, MigrationsConfigMap, metav1.GetOptions{})
+	recorded := err == nil
+	if metaerrors.IsNotFound(err) {
+		record = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: MigrationsConfigMap, Namespace: m.Namespace}}
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read applied migrations: %s", err.Error())
+	}
+
+	applied := []string{}
+	for _, migration := range m.Migrations {
+		if _, ok := record.Data[migration.Name]; ok {
+			continue
+		}
+		required, err := migration.required(mc.From, mc.To)
+		if err != nil {
+			return applied, err
+		}
+		if !required {
+			continue
+		}
+		log.Info("applying migration", "migration", migration.Name, "from", mc.From.String(), "to", mc.To.String())
+		if err := migration.Apply(ctx, mc); err != nil {
+			return applied, fmt.Errorf("migration %s failed: %s", migration.Name, err.Error())
+		}
+		if record.Data == nil {
+			record.Data = map[string]string{}
+		}
+		record.Data[migration.Name] = fmt.Sprintf("%s -> %s at %s", mc.From, mc.To, time.Now().UTC().Format(time.RFC3339))
+		if recorded {
+			record, err = configMaps.Update(ctx, record, metav1.UpdateOptions{})
+		} else {
+			record, err = configMaps.Create(ctx, record, metav1.CreateOptions{})
+		}
+		if err != nil {
+			return applied, fmt.Errorf("unable to record migration %s: %s", migration.Name, err.Error())
+		}
+		recorded = true
+		applied = append(applied, migration.Name)
+	}
+	return applied, nil
+}
+
+// required reports whether the migration applies to moving from the installed
+// to the target chart version
+func (migration Migration) required(from, to *semver.Version) (bool, error) {
+	fromConstraint, err := semver.NewConstraint(migration.From)
+	if err != nil {
+		return false, fmt.Errorf("migration %s has an invalid from constraint: %s", migration.Name, err.Error())
+	}
+	toConstraint, err := semver.NewConstraint(migration.To)
+	if err != nil {
+		return false, fmt.Errorf("migration %s has an invalid to constraint: %s", migration.Name, err.Error())
+	}
+	return fromConstraint.Check(from) && toConstraint.Check(to), nil
+}
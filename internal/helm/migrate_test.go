@@ -0,0 +1,169 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testActionConfig returns an action configuration backed by the memory
+// driver holding the given releases
+func testActionConfig(t *testing.T, releases ...*release.Release) *action.Configuration {
+	store := storage.Init(driver.NewMemory())
+	for _, rel := range releases {
+		assert.NoError(t, store.Create(rel))
+	}
+	return &action.Configuration{
+		Releases:   store,
+		KubeClient: &kubefake.PrintingKubeClient{Out: io.Discard},
+		Log:        func(format string, v ...interface{}) {},
+	}
+}
+
+func testRevision(name string, revision int, version string) *release.Release {
+	return &release.Release{
+		Name:      name,
+		Namespace: "kubearmor",
+		Version:   revision,
+		Info:      &release.Info{Status: release.StatusDeployed},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: name, Version: version}},
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	var calls []string
+	migration := func(name, from, to string) Migration {
+		return Migration{Name: name, From: from, To: to, Apply: func(_ context.Context, mc *MigrationContext) error {
+			calls = append(calls, fmt.Sprintf("%s %s->%s", name, mc.From, mc.To))
+			return nil
+		}}
+	}
+	migrations := []Migration{
+		migration("split-daemonsets", "< 1.4.0", ">= 1.4.0"),
+		migration("rename-relay", ">= 1.3.0, < 1.5.0", ">= 1.5.0"),
+		migration("future", ">= 2.0.0", ">= 2.0.0"),
+	}
+
+	tests := []struct {
+		name     string
+		releases []*release.Release
+		to       string
+		applied  []string
+	}{{
+		name: "fresh install",
+		to:   "v1.5.0",
+	}, {
+		name:     "upgrade across both migrations",
+		releases: []*release.Release{testRevision("kubearmor", 1, "v1.3.9")},
+		to:       "v1.5.0",
+		applied:  []string{"split-daemonsets", "rename-relay"},
+	}, {
+		name:     "latest revision is the installed version",
+		releases: []*release.Release{testRevision("kubearmor", 1, "v1.3.9"), testRevision("kubearmor", 2, "v1.4.1")},
+		to:       "v1.5.0",
+		applied:  []string{"rename-relay"},
+	}, {
+		name:     "target out of range",
+		releases: []*release.Release{testRevision("kubearmor", 1, "v1.3.9")},
+		to:       "v1.3.10",
+		applied:  []string{},
+	}, {
+		name: "legacy installation",
+		releases: []*release.Release{
+			testRevision("kubearmor-operator", 1, "v1.3.4"),
+			testRevision("kubearmor-operator", 2, "v1.4.0"),
+		},
+		to:      "v1.4.0",
+		applied: []string{"split-daemonsets"},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			m := &Migrator{
+				Migrations:        migrations,
+				ActionConfig:      testActionConfig(t, tt.releases...),
+				Clientset:         fake.NewSimpleClientset(),
+				Namespace:         "kubearmor",
+				ReleaseName:       "kubearmor",
+				LegacyReleaseName: LegacyReleaseName,
+			}
+			applied, err := m.Run(context.Background(), tt.to, &MigrationContext{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.applied, applied)
+			assert.Len(t, calls, len(tt.applied))
+
+			// migrations are recorded and not applied again
+			applied, err = m.Run(context.Background(), tt.to, &MigrationContext{})
+			assert.NoError(t, err)
+			assert.Empty(t, applied)
+			assert.Len(t, calls, len(tt.applied))
+			if len(tt.applied) > 0 {
+				record, err := m.Clientset.CoreV1().ConfigMaps("kubearmor").Get(context.Background(), MigrationsConfigMap, metav1.GetOptions{})
+				assert.NoError(t, err)
+				assert.Len(t, record.Data, len(tt.applied))
+			}
+		})
+	}
+}
+
+func TestMigratorFailure(t *testing.T) {
+	attempts := 0
+	m := &Migrator{
+		Migrations: []Migration{{
+			Name: "flaky",
+			From: "< 1.4.0",
+			To:   ">= 1.4.0",
+			Apply: func(context.Context, *MigrationContext) error {
+				attempts++
+				if attempts == 1 {
+					return fmt.Errorf("conflict")
+				}
+				return nil
+			},
+		}, {
+			Name:  "invalid",
+			From:  "not a constraint",
+			To:    ">= 1.4.0",
+			Apply: func(context.Context, *MigrationContext) error { return nil },
+		}},
+		ActionConfig: testActionConfig(t, testRevision("kubearmor", 1, "v1.3.9")),
+		Clientset:    fake.NewSimpleClientset(),
+		Namespace:    "kubearmor",
+		ReleaseName:  "kubearmor",
+	}
+
+	// a failed migration is not recorded and retried
+	_, err := m.Run(context.Background(), "v1.4.0", &MigrationContext{})
+	assert.ErrorContains(t, err, "migration flaky failed: conflict")
+	applied, err := m.Run(context.Background(), "v1.4.0", &MigrationContext{})
+	assert.ErrorContains(t, err, "invalid from constraint")
+	assert.Equal(t, []string{"flaky"}, applied)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestLegacyMigration(t *testing.T) {
+	m := &Migrator{
+		Migrations:        Migrations,
+		ActionConfig:      testActionConfig(t, testRevision("kubearmor-operator", 1, "v1.4.0")),
+		Clientset:         fake.NewSimpleClientset(),
+		Namespace:         "kubearmor",
+		ReleaseName:       "kubearmor",
+		LegacyReleaseName: LegacyReleaseName,
+	}
+	// an operator installed at v1.3.8 or later never deployed legacy resources
+	applied, err := m.Run(context.Background(), "v1.4.0", &MigrationContext{})
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+}
@@ -0,0 +1,150 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+)
+
+// expandNodeProfiles returns the rendered KubeArmor daemonset excluding nodes
+// with a profile, followed by a copy of it for each profile. Other resources
+// are returned unchanged
+func expandNodeProfiles(doc []byte, profiles []operatorv1.NodeProfile) ([][]byte, error) {
+	if len(profiles) == 0 {
+		return [][]byte{doc}, nil
+	}
+	ok, err := targetMatches(doc, daemonSetTarget)
+	if err != nil || !ok {
+		return [][]byte{doc}, err
+	}
+	base := unstructured.Unstructured{}
+	if err := base.UnmarshalJSON(doc); err != nil {
+		return nil, err
+	}
+
+	docs := [][]byte{}
+	for _, profile := range profiles {
+		ds := base.DeepCopy()
+		if err := applyNodeProfile(ds, profile); err != nil {
+			return nil, fmt.Errorf("error applying node profile %s to daemonset %s: %s", profile.Name, base.GetName(), err.Error())
+		}
+		profileDoc, err := ds.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, profileDoc)
+	}
+
+	// nodes of a profile run the profile daemonset only
+	if err := addNodeSelectorRequirement(&base, map[string]interface{}{
+		"key":      defaults.ProfileLabel,
+		"operator": "DoesNotExist",
+	}); err != nil {
+		return nil, err
+	}
+	baseDoc, err := base.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return append([][]byte{baseDoc}, docs...), nil
+}
+
+// ReleaseNodeProfiles returns the names of the node profiles the release
+// deploys daemonsets for
+func ReleaseNodeProfiles(rel *release.Release) (map[string]bool, error) {
+	profiles := map[string]bool{}
+	for _, manifest := range releaseutil.SplitManifests(rel.Manifest) {
+		cleanManifest := removeManifestHeader(manifest)
+		if strings.TrimSpace(cleanManifest) == "" {
+			continue
+		}
+		u := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(cleanManifest), &u.Object); err != nil {
+			return nil, fmt.Errorf("error decoding manifest: %v", err)
+		}
+		if profile := u.GetLabels()[defaults.ProfileLabel]; u.GetKind() == "DaemonSet" && profile != "" {
+			profiles[profile] = true
+		}
+	}
+	return profiles, nil
+}
+
+// applyNodeProfile turns a copy of a KubeArmor daemonset into the daemonset of
+// the nodes labeled with the profile
+func applyNodeProfile(ds *unstructured.Unstructured, profile operatorv1.NodeProfile) error {
+	ds.SetName(ds.GetName() + "-" + profile.Name)
+	labels := ds.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[defaults.ProfileLabel] = profile.Name
+	ds.SetLabels(labels)
+	for _, path := range [][]string{
+		{"spec", "selector", "matchLabels"},
+		{"spec", "template", "metadata", "labels"},
+		{"spec", "template", "spec", "nodeSelector"},
+	} {
+		if err := unstructured.SetNestedField(ds.Object, profile.Name, append(path, defaults.ProfileLabel)...); err != nil {
+			return err
+		}
+	}
+
+	return updateKubeArmorContainer(ds, func(container map[string]interface{}) error {
+		if image := profile.KubeArmorImage.Image; image != "" {
+			container["image"] = image
+		}
+		if policy := profile.KubeArmorImage.ImagePullPolicy; policy != "" && profile.KubeArmorImage.Image != "" {
+			container["imagePullPolicy"] = policy
+		}
+		args, _, err := unstructured.NestedStringSlice(container, "args")
+		if err != nil {
+			return err
+		}
+		// later flags take precedence over the ones rendered by the chart
+		for _, flag := range []struct{ name, value string }{
+			{"defaultFilePosture", string(profile.DefaultFilePosture)},
+			{"defaultCapabilitiesPosture", string(profile.DefaultCapabilitiesPosture)},
+			{"defaultNetworkPosture", string(profile.DefaultNetworkPosture)},
+			{"visibility", profile.DefaultVisibility},
+		} {
+			if flag.value != "" {
+				args = append(args, fmt.Sprintf("-%s=%s", flag.name, flag.value))
+			}
+		}
+		args = append(args, profile.Args...)
+		return unstructured.SetNestedStringSlice(container, args, "args")
+	})
+}
+
+// addNodeSelectorRequirement adds a required node affinity expression to all
+// node selector terms of the pod template
+func addNodeSelectorRequirement(ds *unstructured.Unstructured, requirement map[string]interface{}) error {
+	path := []string{"spec", "template", "spec", "affinity", "nodeAffinity", "requiredDuringSchedulingIgnoredDuringExecution", "nodeSelectorTerms"}
+	terms, _, err := unstructured.NestedSlice(ds.Object, path...)
+	if err != nil {
+		return err
+	}
+	if len(terms) == 0 {
+		terms = []interface{}{map[string]interface{}{}}
+	}
+	for i, t := range terms {
+		term, ok := t.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid node selector term %v", t)
+		}
+		expressions, _, err := unstructured.NestedSlice(term, "matchExpressions")
+		if err != nil {
+			return err
+		}
+		term["matchExpressions"] = append(expressions, requirement)
+		terms[i] = term
+	}
+	return unstructured.SetNestedSlice(ds.Object, terms, path...)
+}
@@ -0,0 +1,190 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+	"helm.sh/helm/v3/pkg/postrender"
+	"helm.sh/helm/v3/pkg/releaseutil"
+)
+
+// postRenderer applies kubearmorconfig patches to the manifests rendered by helm
+type postRenderer struct {
+	patches []operatorv1.Patch
+	// onDelete sets the update strategy of KubeArmor daemonsets to OnDelete,
+	// so that a canary rollout restarts their pods one daemonset at a time
+	onDelete bool
+	// nodeProfiles copy the KubeArmor daemonsets for the nodes of each profile,
+	// patches apply to the copies as well
+	nodeProfiles []operatorv1.NodeProfile
+	// args are appended to the KubeArmor container for charts not rendering
+	// the kubearmor.args value
+	args []string
+	// configMapData is added to the KubeArmor configmap for charts not
+	// rendering the host configmap values
+	configMapData map[string]string
+}
+
+func newPostRenderer(state *releaseState) postrender.PostRenderer {
+	var args []string
+	if !rendersValue(state.chart, ".Values.kubearmor.args") {
+		args = valuesDaemonArgs(state.values)
+	}
+	configMapData := missingConfigMapData(state.chart, state.values)
+	if len(state.patches) == 0 && !state.canary && len(state.nodeProfiles) == 0 && len(args) == 0 && len(configMapData) == 0 {
+		return nil
+	}
+	return &postRenderer{
+		patches:       state.patches,
+		onDelete:      state.canary,
+		nodeProfiles:  state.nodeProfiles,
+		args:          args,
+		configMapData: configMapData,
+	}
+}
+
+// Run implements helm postrender.PostRenderer
+func (p *postRenderer) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	manifests := releaseutil.SplitManifests(renderedManifests.String())
+	keys := make([]string, 0, len(manifests))
+	for key := range manifests {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	matched := make([]bool, len(p.patches))
+	out := &bytes.Buffer{}
+	for _, key := range keys {
+		manifest := removeManifestHeader(manifests[key])
+		if strings.TrimSpace(manifest) == "" {
+			continue
+		}
+		rendered, err := yaml.YAMLToJSON([]byte(manifest))
+		if err != nil {
+			return nil, fmt.Errorf("error converting YAML to JSON: %v", err)
+		}
+		// node profile arguments follow the kubearmorconfig ones
+		if rendered, err = appendDaemonArgs(rendered, p.args); err != nil {
+			return nil, err
+		}
+		if rendered, err = addConfigMapData(rendered, p.configMapData); err != nil {
+			return nil, err
+		}
+		docs, err := expandNodeProfiles(rendered, p.nodeProfiles)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			for i, patch := range p.patches {
+				ok, err := targetMatches(doc, patch.Target)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+				matched[i] = true
+				doc, err = applyPatch(doc, patch)
+				if err != nil {
+					return nil, fmt.Errorf("error applying patch %d: %s", i, err.Error())
+				}
+			}
+			if p.onDelete {
+				if doc, err = setOnDeleteStrategy(doc); err != nil {
+					return nil, err
+				}
+			}
+			patched, err := yaml.JSONToYAML(doc)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(out, "---\n%s", patched)
+		}
+	}
+	for i, ok := range matched {
+		if !ok {
+			return nil, fmt.Errorf("patch %d did not match any rendered resource", i)
+		}
+	}
+	return out, nil
+}
+
+// daemonSetTarget selects the KubeArmor daemonsets
+var daemonSetTarget = operatorv1.PatchTarget{Group: "apps", Kind: "DaemonSet", LabelSelector: defaults.KubeArmorDaemonSetSelector}
+
+// setOnDeleteStrategy sets the update strategy of a KubeArmor daemonset to OnDelete
+func setOnDeleteStrategy(doc []byte) ([]byte, error) {
+	ok, err := targetMatches(doc, daemonSetTarget)
+	if err != nil || !ok {
+		return doc, err
+	}
+	u := unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(doc); err != nil {
+		return nil, err
+	}
+	if err := unstructured.SetNestedMap(u.Object, map[string]interface{}{"type": "OnDelete"}, "spec", "updateStrategy"); err != nil {
+		return nil, err
+	}
+	return u.MarshalJSON()
+}
+
+func targetMatches(doc []byte, target operatorv1.PatchTarget) (bool, error) {
+	u := unstructured.Unstructured{}
+	if err := u.UnmarshalJSON(doc); err != nil {
+		return false, fmt.Errorf("error decoding manifest: %v", err)
+	}
+	gvk := u.GroupVersionKind()
+	if (target.Group != "" && target.Group != gvk.Group) ||
+		(target.Version != "" && target.Version != gvk.Version) ||
+		(target.Kind != "" && target.Kind != gvk.Kind) ||
+		(target.Name != "" && target.Name != u.GetName()) ||
+		(target.Namespace != "" && target.Namespace != u.GetNamespace()) {
+		return false, nil
+	}
+	if target.LabelSelector != "" {
+		selector, err := labels.Parse(target.LabelSelector)
+		if err != nil {
+			return false, fmt.Errorf("invalid patch label selector %q: %s", target.LabelSelector, err.Error())
+		}
+		return selector.Matches(labels.Set(u.GetLabels())), nil
+	}
+	return true, nil
+}
+
+func applyPatch(doc []byte, patch operatorv1.Patch) ([]byte, error) {
+	patchJSON, err := yaml.YAMLToJSON([]byte(patch.Patch))
+	if err != nil {
+		return nil, fmt.Errorf("error converting patch to JSON: %v", err)
+	}
+	switch patch.Type {
+	case operatorv1.JSON6902Patch:
+		jsonPatch, err := jsonpatch.DecodePatch(patchJSON)
+		if err != nil {
+			return nil, err
+		}
+		return jsonPatch.Apply(doc)
+	case operatorv1.StrategicMergePatch, "":
+		u := unstructured.Unstructured{}
+		if err := u.UnmarshalJSON(doc); err != nil {
+			return nil, err
+		}
+		// strategic merge requires the go type, fallback to JSON merge patch
+		// for resources like CRDs not known to the client scheme
+		if obj, err := scheme.Scheme.New(u.GroupVersionKind()); err == nil {
+			return strategicpatch.StrategicMergePatch(doc, patchJSON, obj)
+		}
+		return jsonpatch.MergePatch(doc, patchJSON)
+	}
+	return nil, fmt.Errorf("unsupported patch type %s", patch.Type)
+}
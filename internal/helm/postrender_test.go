@@ -0,0 +1,156 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+)
+
+var manifests = `---
+# Source: kubearmor/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  labels:
+    kubearmor-app: kubearmor-configmap
+  name: kubearmor-config
+  namespace: kubearmor
+data:
+  visibility: process
+---
+# Source: kubearmor/templates/daemonset.yaml
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  labels:
+    kubearmor-app: kubearmor
+  name: kubearmor-bpf-containerd-98c2c
+  namespace: kubearmor
+spec:
+  template:
+    spec:
+      containers:
+      - name: kubearmor
+        image: kubearmor/kubearmor:stable
+`
+
+func TestPostRenderer(t *testing.T) {
+	renderer := newPostRenderer(&releaseState{patches: []operatorv1.Patch{
+		{
+			Target: operatorv1.PatchTarget{Kind: "DaemonSet", LabelSelector: "kubearmor-app=kubearmor"},
+			Patch: `
+metadata:
+  labels:
+    cost-center: security
+spec:
+  template:
+    spec:
+      containers:
+      - name: sidecar
+        image: busybox
+`,
+		},
+		{
+			Target: operatorv1.PatchTarget{Kind: "ConfigMap", Name: "kubearmor-config"},
+			Type:   operatorv1.JSON6902Patch,
+			Patch:  `[{"op": "replace", "path": "/data/visibility", "value": "process,file"}]`,
+		},
+	}})
+
+	out, err := renderer.Run(bytes.NewBufferString(manifests))
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "cost-center: security")
+	// strategic merge keeps existing containers
+	assert.Contains(t, out.String(), "name: kubearmor\n")
+	assert.Contains(t, out.String(), "name: sidecar")
+	assert.Contains(t, out.String(), "visibility: process,file")
+
+	// patches not matching any resource are rejected
+	renderer = newPostRenderer(&releaseState{patches: []operatorv1.Patch{
+		{
+			Target: operatorv1.PatchTarget{Kind: "Deployment", Name: "missing"},
+			Patch:  `metadata: {labels: {a: b}}`,
+		},
+	}})
+	_, err = renderer.Run(bytes.NewBufferString(manifests))
+	assert.Error(t, err)
+
+	assert.Nil(t, newPostRenderer(&releaseState{}))
+}
+
+func TestPostRendererOnDelete(t *testing.T) {
+	renderer := newPostRenderer(&releaseState{canary: true})
+	out, err := renderer.Run(bytes.NewBufferString(manifests))
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "updateStrategy:\n    type: OnDelete")
+	assert.Equal(t, 1, strings.Count(out.String(), "updateStrategy"), "only daemonsets are updated on delete")
+}
+
+func TestRenderWithPatches(t *testing.T) {
+	chart, err := getEmbeddedHelmChart("kubearmor", "v1.3.8")
+	assert.NoError(t, err)
+
+	ctrl := Controller{
+		chartName: "kubearmor",
+		namespace: "kubearmor",
+		chart:     chart,
+		patches: []operatorv1.Patch{
+			{
+				Target: operatorv1.PatchTarget{Kind: "DaemonSet"},
+				Patch:  `{"metadata": {"annotations": {"example.com/scc": "privileged"}}}`,
+			},
+		},
+	}
+	ctrl.UpdateNodeConfigHelmValues([]map[string]interface{}{
+		{
+			"config": map[string]interface{}{
+				"enforcer": "bpf",
+				"runtime":  "containerd",
+				"socket":   "run_containerd_containerd.sock",
+				"arch":     "amd64",
+				"btf":      "yes",
+			},
+		},
+	})
+
+	rel, err := ctrl.render(context.Background(), ctrl.snapshot())
+	assert.NoError(t, err)
+	assert.Contains(t, rel.Manifest, "example.com/scc: privileged")
+}
+
+func TestPostRendererNodeProfiles(t *testing.T) {
+	renderer := newPostRenderer(&releaseState{
+		nodeProfiles: []operatorv1.NodeProfile{{
+			Name:               "gpu",
+			DefaultFilePosture: "audit",
+			KubeArmorImage:     operatorv1.ImageSpec{Image: "kubearmor/kubearmor:v1.4.0", ImagePullPolicy: "IfNotPresent"},
+			Args:               []string{"-enableKubeArmorHostPolicy"},
+		}},
+		patches: []operatorv1.Patch{{
+			Target: operatorv1.PatchTarget{Kind: "DaemonSet", LabelSelector: "kubearmor.io/profile=gpu"},
+			Patch:  `{"metadata": {"annotations": {"example.com/pool": "gpu"}}}`,
+		}},
+	})
+	out, err := renderer.Run(bytes.NewBufferString(manifests))
+	assert.NoError(t, err)
+	docs := strings.Split(out.String(), "---\n")[1:]
+	assert.Len(t, docs, 3)
+
+	// nodes of a profile are excluded from the default daemonset
+	assert.Contains(t, docs[1], "name: kubearmor-bpf-containerd-98c2c\n")
+	assert.Contains(t, docs[1], "- key: kubearmor.io/profile\n                operator: DoesNotExist")
+	assert.Contains(t, docs[1], "image: kubearmor/kubearmor:stable")
+	assert.NotContains(t, docs[1], "example.com/pool")
+
+	assert.Contains(t, docs[2], "name: kubearmor-bpf-containerd-98c2c-gpu\n")
+	assert.Contains(t, docs[2], "nodeSelector:\n        kubearmor.io/profile: gpu")
+	assert.Contains(t, docs[2], "image: kubearmor/kubearmor:v1.4.0")
+	assert.Contains(t, docs[2], "imagePullPolicy: IfNotPresent")
+	assert.Contains(t, docs[2], "- -defaultFilePosture=audit\n        - -enableKubeArmorHostPolicy")
+	assert.Contains(t, docs[2], "example.com/pool: gpu")
+}
@@ -0,0 +1,47 @@
+package helm
+
+import "strings"
+
+// redactedValue replaces sensitive helm values in logs
+const redactedValue = "<redacted>"
+
+// sensitiveKeys are case insensitive substrings of value keys holding secrets
+var sensitiveKeys = []string{"password", "passwd", "token", "secret", "credential", "apikey", "privatekey"}
+
+// RedactValues returns a copy of helm values with sensitive entries replaced,
+// it is meant for logging and must not be used to install a release
+func RedactValues(vals map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(vals))
+	for k, v := range vals {
+		if isSensitiveKey(k) && v != nil {
+			out[k] = redactedValue
+			continue
+		}
+		out[k] = redactValue(v)
+	}
+	return out
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return RedactValues(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = redactValue(v[i])
+		}
+		return out
+	}
+	return v
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/types"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+)
+
+// ErrValuesIncomplete is returned by UpgradeRelease until both the node
+// configuration and a kubearmorconfig instance are known
+var ErrValuesIncomplete = errors.New("either nodes are not processed or kubearmorconfig CR instance not present")
+
+// bounds of the backoff failed upgrades are retried with
+const (
+	upgradeRetryBase = 10 * time.Second
+	upgradeRetryMax  = 5 * time.Minute
+)
+
+// UpgradeResult reports the progress of an asynchronous upgrade
+type UpgradeResult struct {
+	// Phase is one of the operatorv1 upgrade phases
+	Phase string
+	// Release deployed by a successful upgrade
+	Release *release.Release
+	// Err the upgrade failed or was deferred with
+	Err error
+	// Reasons the upgrade was requested for
+	Reasons []string
+	// Config is the kubearmorconfig the upgrade was made from
+	Config   types.NamespacedName
+	Started  time.Time
+	Finished time.Time
+}
+
+// upgradeQueue coalesces upgrade requests for the upgrade worker
+type upgradeQueue struct {
+	mutex sync.Mutex
+	// requests wakes up the worker, a single pending request covers all
+	// changes made until the worker takes it
+	requests  chan struct{}
+	reasons   []string
+	listeners []func(UpgradeResult)
+	// retry requests a failed or deferred upgrade again
+	retry    *time.Timer
+	failures int
+}
+
+func (q *upgradeQueue) channel() chan struct{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.requests == nil {
+		q.requests = make(chan struct{}, 1)
+	}
+	return q.requests
+}
+
+// RequestUpgrade asks the upgrade worker to apply the current values to the
+// release. It never blocks, requests made while an upgrade runs are applied by
+// a single upgrade once it has finished
+func (ctrl *Controller) RequestUpgrade(reason string) {
+	q := &ctrl.upgrades
+	q.mutex.Lock()
+	if len(q.reasons) == 0 || q.reasons[len(q.reasons)-1] != reason {
+		q.reasons = append(q.reasons, reason)
+	}
+	q.mutex.Unlock()
+	select {
+	case q.channel() <- struct{}{}:
+	default:
+	}
+}
+
+// OnUpgrade registers fn to be called with the progress of every upgrade made
+// by the upgrade worker. It must be called before RunUpgrades
+func (ctrl *Controller) OnUpgrade(fn func(UpgradeResult)) {
+	ctrl.upgrades.mutex.Lock()
+	defer ctrl.upgrades.mutex.Unlock()
+	ctrl.upgrades.listeners = append(ctrl.upgrades.listeners, fn)
+}
+
+// RunUpgrades applies requested upgrades one at a time until ctx is done.
+// Failed upgrades are retried with a backoff, deferred ones at the start of the
+// next maintenance window
+func (ctrl *Controller) RunUpgrades(ctx context.Context) {
+	requests := ctrl.upgrades.channel()
+	for {
+		select {
+		case <-ctx.Done():
+			ctrl.upgrades.mutex.Lock()
+			if ctrl.upgrades.retry != nil {
+				ctrl.upgrades.retry.Stop()
+			}
+			ctrl.upgrades.mutex.Unlock()
+			return
+		case <-requests:
+			ctrl.runUpgrade(ctx)
+		}
+	}
+}
+
+// runUpgrade runs a single requested upgrade and reports its progress
+func (ctrl *Controller) runUpgrade(ctx context.Context) {
+	q := &ctrl.upgrades
+	q.mutex.Lock()
+	reasons := q.reasons
+	q.reasons = nil
+	if q.retry != nil {
+		q.retry.Stop()
+		q.retry = nil
+	}
+	q.mutex.Unlock()
+
+	ctrl.stateMutex.Lock()
+	config := ctrl.kaConfig
+	ctrl.stateMutex.Unlock()
+	result := UpgradeResult{
+		Phase:   operatorv1.UpgradeRunning,
+		Reasons: reasons,
+		Config:  config,
+		Started: time.Now(),
+	}
+	log.Info("upgrading release", "reasons", reasons)
+	ctrl.notifyUpgrade(result)
+
+	result.Release, result.Err = ctrl.UpgradeRelease(ctx)
+	result.Finished = time.Now()
+	if ctx.Err() != nil {
+		// the operator is shutting down, the next leader upgrades again
+		return
+	}
+
+	var retryAfter time.Duration
+	var deferred *DeferredError
+	switch {
+	case errors.As(result.Err, &deferred):
+		result.Phase = operatorv1.UpgradeDeferred
+		q.failures = 0
+		if !deferred.Next.IsZero() {
+			retryAfter = max(time.Until(deferred.Next), time.Second)
+		}
+	case errors.Is(result.Err, ErrValuesIncomplete):
+		// requested again once the missing values are known, reported as
+		// deferred so that the running upgrade doesn't stay in the status
+		result.Phase = operatorv1.UpgradeDeferred
+		log.Info("skipping upgrade", "reason", result.Err.Error())
+	case result.Err != nil:
+		result.Phase = operatorv1.UpgradeFailed
+		q.failures++
+		retryAfter = min(upgradeRetryBase<<min(q.failures-1, 10), upgradeRetryMax)
+		log.Error(result.Err, "error upgrading release", "retryAfter", retryAfter)
+	default:
+		result.Phase = operatorv1.UpgradeSucceeded
+		q.failures = 0
+		rel := result.Release
+		log.Info("successfully upgraded release", "name", rel.Name, "version", rel.Version,
+			"status", rel.Info.Status, "chartVersion", rel.Chart.Metadata.Version)
+		if log.V(2).Enabled() {
+			var manifests bytes.Buffer
+			fmt.Fprint(&manifests, strings.TrimSpace(rel.Manifest))
+			for _, m := range rel.Hooks {
+				fmt.Fprintf(&manifests, "---\n# Source: %s\n%s\n", m.Path, m.Manifest)
+			}
+			log.V(2).Info("release manifests", "manifests", manifests.String())
+		}
+	}
+
+	if retryAfter > 0 {
+		q.mutex.Lock()
+		// keep the reasons of the retried upgrade
+		q.reasons = append(reasons, q.reasons...)
+		q.retry = time.AfterFunc(retryAfter, func() {
+			select {
+			case q.channel() <- struct{}{}:
+			default:
+			}
+		})
+		q.mutex.Unlock()
+	}
+	ctrl.notifyUpgrade(result)
+}
+
+func (ctrl *Controller) notifyUpgrade(result UpgradeResult) {
+	ctrl.upgrades.mutex.Lock()
+	listeners := ctrl.upgrades.listeners
+	ctrl.upgrades.mutex.Unlock()
+	for _, fn := range listeners {
+		fn(result)
+	}
+}
@@ -0,0 +1,93 @@
+package helm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+)
+
+func TestRunUpgrades(t *testing.T) {
+	ctrl := Controller{chartName: "kubearmor", namespace: "kubearmor"}
+	ctrl.UpdateHelmValuesFromKubeArmorConfig(&operatorv1.KubeArmorConfig{ObjectMeta: metav1.ObjectMeta{
+		Name:        "kubearmorconfig-default",
+		Namespace:   "kubearmor",
+		Annotations: map[string]string{defaults.PausedAnnotation: "true"},
+	}})
+
+	results := make(chan UpgradeResult, 10)
+	ctrl.OnUpgrade(func(result UpgradeResult) { results <- result })
+
+	// requests made before the worker runs are coalesced into one upgrade
+	ctrl.RequestUpgrade("kubearmorconfig changed")
+	ctrl.RequestUpgrade("node configuration changed")
+	ctrl.RequestUpgrade("node configuration changed")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ctrl.RunUpgrades(ctx)
+		close(done)
+	}()
+
+	next := func() UpgradeResult {
+		select {
+		case result := <-results:
+			return result
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for upgrade result")
+		}
+		return UpgradeResult{}
+	}
+	result := next()
+	assert.Equal(t, operatorv1.UpgradeRunning, result.Phase)
+	assert.Equal(t, []string{"kubearmorconfig changed", "node configuration changed"}, result.Reasons)
+	assert.Equal(t, "kubearmorconfig-default", result.Config.Name)
+
+	result = next()
+	assert.Equal(t, operatorv1.UpgradeDeferred, result.Phase)
+	assert.IsType(t, &DeferredError{}, result.Err)
+	assert.False(t, result.Finished.IsZero())
+
+	// paused upgrades are not retried until requested again
+	select {
+	case result := <-results:
+		t.Fatalf("unexpected upgrade result %s", result.Phase)
+	case <-time.After(100 * time.Millisecond):
+	}
+	ctrl.RequestUpgrade("kubearmorconfig changed")
+	assert.Equal(t, operatorv1.UpgradeRunning, next().Phase)
+	assert.Equal(t, operatorv1.UpgradeDeferred, next().Phase)
+
+	cancel()
+	<-done
+}
+
+func TestRunUpgradesValuesIncomplete(t *testing.T) {
+	// the node configuration is not known yet
+	ctrl := Controller{chartName: "kubearmor", namespace: "kubearmor"}
+	ctrl.UpdateHelmValuesFromKubeArmorConfig(&operatorv1.KubeArmorConfig{ObjectMeta: metav1.ObjectMeta{
+		Name:      "kubearmorconfig-default",
+		Namespace: "kubearmor",
+	}})
+
+	var phases []string
+	ctrl.OnUpgrade(func(result UpgradeResult) {
+		phases = append(phases, result.Phase)
+		if result.Phase != operatorv1.UpgradeRunning {
+			assert.ErrorIs(t, result.Err, ErrValuesIncomplete)
+			assert.False(t, result.Finished.IsZero())
+		}
+	})
+	ctrl.RequestUpgrade("kubearmorconfig changed")
+	ctrl.runUpgrade(context.Background())
+	assert.Equal(t, []string{operatorv1.UpgradeRunning, operatorv1.UpgradeDeferred}, phases)
+
+	// not retried until requested again
+	assert.Nil(t, ctrl.upgrades.retry)
+}
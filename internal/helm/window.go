@@ -0,0 +1,70 @@
+package helm
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/schedule"
+)
+
+// DeferredError is returned by UpgradeRelease when changes may not be applied
+// now, they are applied by the next upgrade allowed
+type DeferredError struct {
+	// Reason changes are deferred
+	Reason string
+	// Next is the start of the next maintenance window, zero if paused
+	Next time.Time
+}
+
+func (e *DeferredError) Error() string {
+	if e.Next.IsZero() {
+		return "release changes deferred: " + e.Reason
+	}
+	return fmt.Sprintf("release changes deferred until %s: %s", e.Next.Format(time.RFC3339), e.Reason)
+}
+
+// Paused reports whether changes to the release are paused by the spec or the
+// paused annotation of the kubearmorconfig
+func Paused(kaConfig *operatorv1.KubeArmorConfig) bool {
+	return kaConfig.Spec.Paused || kaConfig.Annotations[defaults.PausedAnnotation] == "true"
+}
+
+// RolloutFailed reports whether the canary rollout of the current generation
+// of the kubearmorconfig was paused or rolled back
+func RolloutFailed(kaConfig *operatorv1.KubeArmorConfig) bool {
+	rollout := kaConfig.Status.Rollout
+	return rollout != nil && rollout.ObservedGeneration == kaConfig.Generation &&
+		(rollout.Phase == operatorv1.RolloutPaused || rollout.Phase == operatorv1.RolloutRolledBack)
+}
+
+// deferral returns a DeferredError if changes of the state may not be applied at now
+func deferral(state *releaseState, now time.Time) error {
+	if state.paused {
+		return &DeferredError{Reason: "reconciliation is paused"}
+	}
+	if state.rolloutFailed {
+		return &DeferredError{Reason: "the rollout of the kubearmorconfig spec failed, waiting for a spec change"}
+	}
+	open, next, err := schedule.Open(state.maintenanceWindows, now)
+	if err != nil {
+		return fmt.Errorf("invalid maintenance windows: %s", err.Error())
+	}
+	if !open {
+		return &DeferredError{Reason: "outside of the maintenance windows", Next: next}
+	}
+	return nil
+}
+
+// checkDeferral records an event if changes are deferred
+func (ctrl *Controller) checkDeferral(state *releaseState, now time.Time) error {
+	err := deferral(state, now)
+	if deferred, ok := err.(*DeferredError); ok {
+		log.Info("deferring release changes", "reason", deferred.Reason, "next", deferred.Next)
+		ctrl.recordEvent(corev1.EventTypeNormal, defaults.ReleaseDeferredReason, "%s", deferred.Error())
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package helm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/defaults"
+)
+
+func TestUpgradeDeferred(t *testing.T) {
+	ctrl := Controller{chartName: "kubearmor", namespace: "kubearmor"}
+	config := &operatorv1.KubeArmorConfig{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{defaults.PausedAnnotation: "true"},
+	}}
+	ctrl.UpdateHelmValuesFromKubeArmorConfig(config)
+	_, err := ctrl.UpgradeRelease(context.Background())
+	assert.IsType(t, &DeferredError{}, err)
+	assert.True(t, err.(*DeferredError).Next.IsZero())
+
+	// a window that never contains now
+	now := time.Now().UTC()
+	config = &operatorv1.KubeArmorConfig{Spec: operatorv1.KubeArmorConfigSpec{
+		MaintenanceWindows: []operatorv1.MaintenanceWindow{{
+			Schedule: "0 0 1 1 *",
+			Duration: metav1.Duration{Duration: time.Minute},
+		}},
+	}}
+	ctrl.UpdateHelmValuesFromKubeArmorConfig(config)
+	if now.Month() == time.January && now.Day() == 1 && now.Hour() == 0 && now.Minute() == 0 {
+		t.Skip("inside the maintenance window")
+	}
+	_, err = ctrl.UpgradeRelease(context.Background())
+	assert.IsType(t, &DeferredError{}, err)
+	assert.Equal(t, time.January, err.(*DeferredError).Next.Month())
+
+	assert.NoError(t, deferral(ctrl.snapshot(), time.Date(2025, time.January, 1, 0, 0, 30, 0, time.UTC)))
+
+	config.Spec.MaintenanceWindows[0].Schedule = "0 0 1 1"
+	ctrl.UpdateHelmValuesFromKubeArmorConfig(config)
+	assert.ErrorContains(t, deferral(ctrl.snapshot(), now), "invalid maintenance windows")
+}
@@ -0,0 +1,89 @@
+// Package metrics defines the operator specific prometheus metrics, they are
+// served along with the controller-runtime metrics on --metrics-bind-address
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "kubearmor_operator"
+
+// result label values
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	// HelmOperationDuration tracks duration of helm install and upgrade operations
+	HelmOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "helm_operation_duration_seconds",
+		Help:      "Duration of helm operations performed on the KubeArmor release",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"operation", "result"})
+
+	// HelmOperations counts helm operations by result
+	HelmOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "helm_operations_total",
+		Help:      "Number of helm operations performed on the KubeArmor release",
+	}, []string{"operation", "result"})
+
+	// ReleaseRevision reports the current revision of the KubeArmor release
+	ReleaseRevision = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "release_revision",
+		Help:      "Current revision of the KubeArmor helm release",
+	})
+
+	// ReleaseInfo reports the chart and app version of the KubeArmor release
+	ReleaseInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "release_info",
+		Help:      "Chart information of the deployed KubeArmor helm release",
+	}, []string{"release", "chart_version", "app_version"})
+
+	// Nodes reports the number of nodes for each detected node configuration
+	Nodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "nodes",
+		Help:      "Number of nodes per detected enforcer, runtime and btf configuration",
+	}, []string{"enforcer", "runtime", "btf"})
+
+	// SnitchJobs counts finished snitch jobs by result
+	SnitchJobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snitch_jobs_total",
+		Help:      "Number of finished snitch jobs",
+	}, []string{"result"})
+
+	// PendingNodeChanges reports node configuration changes not yet applied
+	// to the KubeArmor release
+	PendingNodeChanges = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_node_changes",
+		Help:      "Number of node configuration changes not yet applied to the KubeArmor release",
+	})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		HelmOperationDuration,
+		HelmOperations,
+		ReleaseRevision,
+		ReleaseInfo,
+		Nodes,
+		SnitchJobs,
+		PendingNodeChanges,
+	)
+}
+
+// Result returns the result label value for the given error
+func Result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
@@ -0,0 +1,98 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		_, err := ParseCron(spec)
+		assert.Error(t, err, spec)
+	}
+
+	c, err := ParseCron("0,30 1-3 * * 7")
+	assert.NoError(t, err)
+	assert.True(t, c.Matches(date("2024-06-02T02:30:00Z")), "sunday as 7")
+	assert.False(t, c.Matches(date("2024-06-03T02:30:00Z")))
+	assert.False(t, c.Matches(date("2024-06-02T04:00:00Z")))
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		spec, from, next string
+	}{
+		{"*/15 * * * *", "2024-06-03T10:07:42Z", "2024-06-03T10:15:00Z"},
+		{"0 1 * * 1-5", "2024-06-07T01:00:00Z", "2024-06-10T01:00:00Z"},
+		{"30 22 * * *", "2024-12-31T23:00:00Z", "2025-01-01T22:30:00Z"},
+		// either day field matches when both are restricted
+		{"0 0 15 * 1", "2024-06-04T00:00:00Z", "2024-06-10T00:00:00Z"},
+		{"0 0 29 2 *", "2024-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"0 0 30 2 *", "2024-03-01T00:00:00Z", ""},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.spec)
+		assert.NoError(t, err)
+		next := c.Next(date(tt.from))
+		if tt.next == "" {
+			assert.True(t, next.IsZero(), tt.spec)
+			continue
+		}
+		assert.Equal(t, date(tt.next), next, tt.spec)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	windows := []operatorv1.MaintenanceWindow{
+		{Schedule: "0 22 * * 1-5", Duration: metav1.Duration{Duration: 4 * time.Hour}},
+		{Schedule: "0 8 * * 6", Duration: metav1.Duration{Duration: 2 * time.Hour}, TimeZone: "Asia/Kolkata"},
+	}
+
+	open, _, err := Open(nil, date("2024-06-03T12:00:00Z"))
+	assert.NoError(t, err)
+	assert.True(t, open)
+
+	// monday night window spans midnight
+	open, _, err = Open(windows, date("2024-06-04T01:59:00Z"))
+	assert.NoError(t, err)
+	assert.True(t, open)
+
+	open, next, err := Open(windows, date("2024-06-04T02:00:00Z"))
+	assert.NoError(t, err)
+	assert.False(t, open)
+	assert.True(t, next.Equal(date("2024-06-04T22:00:00Z")))
+
+	// friday night, the saturday window is 08:00 IST
+	open, next, err = Open(windows, date("2024-06-08T02:00:00Z"))
+	assert.NoError(t, err)
+	assert.False(t, open)
+	assert.True(t, next.Equal(date("2024-06-08T02:30:00Z")))
+
+	_, _, err = Open([]operatorv1.MaintenanceWindow{{Schedule: "0 1 * * *"}}, time.Now())
+	assert.ErrorContains(t, err, "duration")
+	_, _, err = Open([]operatorv1.MaintenanceWindow{{Schedule: "0 1 * * *", Duration: metav1.Duration{Duration: time.Hour}, TimeZone: "Mars/Olympus"}}, time.Now())
+	assert.ErrorContains(t, err, "time zone")
+}
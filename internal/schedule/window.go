@@ -0,0 +1,76 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+)
+
+// maxWindowDuration bounds windows so that checking them stays cheap
+const maxWindowDuration = 7 * 24 * time.Hour
+
+// Window is a parsed maintenance window
+type Window struct {
+	start    *Cron
+	duration time.Duration
+	location *time.Location
+}
+
+// NewWindow parses a maintenance window
+func NewWindow(w operatorv1.MaintenanceWindow) (*Window, error) {
+	start, err := ParseCron(w.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	if w.Duration.Duration <= 0 || w.Duration.Duration > maxWindowDuration {
+		return nil, fmt.Errorf("maintenance window duration %s must be positive and at most %s", w.Duration.Duration, maxWindowDuration)
+	}
+	location := time.UTC
+	if w.TimeZone != "" {
+		if location, err = time.LoadLocation(w.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid maintenance window time zone %q: %s", w.TimeZone, err.Error())
+		}
+	}
+	return &Window{start: start, duration: w.Duration.Duration, location: location}, nil
+}
+
+// Contains reports whether t is in a window started within its duration before t
+func (w *Window) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	earliest := t.Add(-w.duration)
+	for start := t.Truncate(time.Minute); start.After(earliest); start = start.Add(-time.Minute) {
+		if w.start.Matches(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStart returns the start of the next window after t, zero if there is none
+func (w *Window) NextStart(t time.Time) time.Time {
+	return w.start.Next(t.In(w.location))
+}
+
+// Open reports whether changes may be applied at t. With no windows changes are
+// always allowed, otherwise t must be in one of them. If it is not, the start of
+// the next window is returned as well
+func Open(windows []operatorv1.MaintenanceWindow, t time.Time) (bool, time.Time, error) {
+	if len(windows) == 0 {
+		return true, time.Time{}, nil
+	}
+	var next time.Time
+	for _, spec := range windows {
+		w, err := NewWindow(spec)
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		if w.Contains(t) {
+			return true, time.Time{}, nil
+		}
+		if start := w.NextStart(t); !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+	return false, next, nil
+}
@@ -0,0 +1,267 @@
+// Package supportbundle collects the state of the KubeArmor installation into a
+// redacted tar.gz archive for troubleshooting
+package supportbundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+	"github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/internal/helm"
+)
+
+// DefaultPodSelectors select the kubearmor, snitch and operator pods logs are collected from
+var DefaultPodSelectors = []string{"kubearmor-app", "control-plane=controller-manager"}
+
+// DefaultLogLines is the number of log lines collected per container
+const DefaultLogLines int64 = 1000
+
+// sensitiveText matches secrets assigned in log lines, e.g. token=abc or "password": "abc"
+var sensitiveText = regexp.MustCompile(`(?i)((?:password|passwd|token|secret|credential|api[_-]?key)["']?\s*[:=]\s*)("[^"]*"|'[^']*'|[^\s,;}]+)`)
+
+// Collector collects the support bundle
+type Collector struct {
+	// Clientset reads nodes, pods, logs and events
+	Clientset kubernetes.Interface
+	// Client reads kubearmorconfig instances
+	Client client.Reader
+	// Helm reads the release history
+	Helm *helm.Controller
+	// Namespace KubeArmor and the operator are deployed in
+	Namespace string
+	// PodSelectors are the label selectors of pods to collect logs from
+	PodSelectors []string
+	// LogLines is the number of log lines collected per container
+	LogLines int64
+}
+
+// bundle is the archive being written, collection errors are recorded instead
+// of aborting so that a partial bundle is still produced
+type bundle struct {
+	tw     *tar.Writer
+	now    time.Time
+	errors []string
+}
+
+func (b *bundle) add(name string, data []byte) error {
+	if err := b.tw.WriteHeader(&tar.Header{
+		Name:    "kubearmor-support-bundle/" + name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: b.now,
+	}); err != nil {
+		return err
+	}
+	_, err := b.tw.Write(data)
+	return err
+}
+
+func (b *bundle) addYAML(name string, obj interface{}) error {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		b.errorf("%s: %s", name, err.Error())
+		return nil
+	}
+	return b.add(name, data)
+}
+
+func (b *bundle) errorf(format string, args ...interface{}) {
+	b.errors = append(b.errors, fmt.Sprintf(format, args...))
+}
+
+// Write collects the support bundle into w as a tar.gz archive
+func (c *Collector) Write(ctx context.Context, w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	b := &bundle{tw: tar.NewWriter(gw), now: time.Now()}
+
+	steps := []func(context.Context, *bundle) error{
+		c.collectKubeArmorConfigs,
+		c.collectNodes,
+		c.collectRelease,
+		c.collectEvents,
+		c.collectLogs,
+	}
+	for _, step := range steps {
+		if err := step(ctx, b); err != nil {
+			return err
+		}
+	}
+	if len(b.errors) > 0 {
+		if err := b.add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// Bytes collects the support bundle into memory
+func (c *Collector) Bytes(ctx context.Context) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.Write(ctx, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Collector) collectKubeArmorConfigs(ctx context.Context, b *bundle) error {
+	if c.Client == nil {
+		return nil
+	}
+	configs := &operatorv1.KubeArmorConfigList{}
+	if err := c.Client.List(ctx, configs); err != nil {
+		b.errorf("kubearmorconfigs: %s", err.Error())
+		return nil
+	}
+	for i := range configs.Items {
+		config := &configs.Items[i]
+		config.ManagedFields = nil
+		// the last applied configuration holds the unredacted values
+		delete(config.Annotations, corev1.LastAppliedConfigAnnotation)
+		if config.Spec.Values != nil && len(config.Spec.Values.Raw) > 0 {
+			values := map[string]interface{}{}
+			if err := json.Unmarshal(config.Spec.Values.Raw, &values); err != nil {
+				config.Spec.Values = nil
+				continue
+			}
+			raw, _ := json.Marshal(helm.RedactValues(values))
+			config.Spec.Values = &apiextensionsv1.JSON{Raw: raw}
+		}
+	}
+	return b.addYAML("kubearmorconfigs.yaml", configs)
+}
+
+// nodeInfo is the part of a node relevant to KubeArmor
+type nodeInfo struct {
+	Name     string                `json:"name"`
+	Labels   map[string]string     `json:"labels"`
+	NodeInfo corev1.NodeSystemInfo `json:"nodeInfo"`
+}
+
+func (c *Collector) collectNodes(ctx context.Context, b *bundle) error {
+	nodes, err := c.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		b.errorf("nodes: %s", err.Error())
+		return nil
+	}
+	infos := []nodeInfo{}
+	for _, n := range nodes.Items {
+		infos = append(infos, nodeInfo{Name: n.Name, Labels: n.Labels, NodeInfo: n.Status.NodeInfo})
+	}
+	return b.addYAML("nodes.yaml", infos)
+}
+
+// releaseInfo is a release revision without its manifest, which holds secrets
+type releaseInfo struct {
+	Revision     int            `json:"revision"`
+	Status       release.Status `json:"status"`
+	ChartVersion string         `json:"chartVersion"`
+	AppVersion   string         `json:"appVersion"`
+	Updated      time.Time      `json:"updated"`
+	Description  string         `json:"description"`
+}
+
+func (c *Collector) collectRelease(_ context.Context, b *bundle) error {
+	if c.Helm == nil {
+		return nil
+	}
+	releases, err := c.Helm.ReleaseHistory(10)
+	if err != nil {
+		b.errorf("helm history: %s", err.Error())
+		return nil
+	}
+	history := []releaseInfo{}
+	for _, rel := range releases {
+		history = append(history, releaseInfo{
+			Revision:     rel.Version,
+			Status:       rel.Info.Status,
+			ChartVersion: rel.Chart.Metadata.Version,
+			AppVersion:   rel.Chart.Metadata.AppVersion,
+			Updated:      rel.Info.LastDeployed.Time,
+			Description:  rel.Info.Description,
+		})
+	}
+	if err := b.addYAML("helm/history.yaml", history); err != nil {
+		return err
+	}
+	if len(releases) > 0 {
+		return b.addYAML("helm/values.yaml", helm.RedactValues(releases[len(releases)-1].Config))
+	}
+	return nil
+}
+
+func (c *Collector) collectEvents(ctx context.Context, b *bundle) error {
+	events, err := c.Clientset.CoreV1().Events(c.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		b.errorf("events: %s", err.Error())
+		return nil
+	}
+	var out strings.Builder
+	for _, e := range events.Items {
+		fmt.Fprintf(&out, "%s\t%s\t%s/%s\t%s\t%s\n", e.LastTimestamp.Format(time.RFC3339), e.Type,
+			e.InvolvedObject.Kind, e.InvolvedObject.Name, e.Reason, RedactText(e.Message))
+	}
+	return b.add("events.txt", []byte(out.String()))
+}
+
+func (c *Collector) collectLogs(ctx context.Context, b *bundle) error {
+	selectors := c.PodSelectors
+	if len(selectors) == 0 {
+		selectors = DefaultPodSelectors
+	}
+	lines := c.LogLines
+	if lines <= 0 {
+		lines = DefaultLogLines
+	}
+	seen := map[string]bool{}
+	for _, selector := range selectors {
+		pods, err := c.Clientset.CoreV1().Pods(c.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			b.errorf("pods %s: %s", selector, err.Error())
+			continue
+		}
+		for _, pod := range pods.Items {
+			if seen[pod.Name] {
+				continue
+			}
+			seen[pod.Name] = true
+			for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+				logs, err := c.Clientset.CoreV1().Pods(c.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+					Container: container.Name,
+					TailLines: &lines,
+				}).DoRaw(ctx)
+				if err != nil {
+					b.errorf("logs %s/%s: %s", pod.Name, container.Name, err.Error())
+					continue
+				}
+				if err := b.add(fmt.Sprintf("logs/%s/%s.log", pod.Name, container.Name), []byte(RedactText(string(logs)))); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// RedactText replaces secrets assigned in free text like logs
+func RedactText(text string) string {
+	return sensitiveText.ReplaceAllString(text, "${1}<redacted>")
+}
@@ -0,0 +1,75 @@
+package supportbundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	operatorv1 "github.com/kubearmor/KubeArmor/pkg/KubeArmorOperator/api/v1"
+)
+
+func TestRedactText(t *testing.T) {
+	assert.Equal(t, "connecting with token=<redacted> to relay", RedactText("connecting with token=abc123 to relay"))
+	assert.Equal(t, `{"password": <redacted>, "user": "admin"}`, RedactText(`{"password": "hunter2", "user": "admin"}`))
+	assert.Equal(t, "apiKey: <redacted>", RedactText("apiKey: xyz"))
+	assert.Equal(t, "policy enforced", RedactText("policy enforced"))
+}
+
+func TestWrite(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, operatorv1.AddToScheme(scheme))
+	k8sClient := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(&operatorv1.KubeArmorConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "kubearmor", Annotations: map[string]string{
+			corev1.LastAppliedConfigAnnotation:      `{"spec":{"values":{"relay":{"tlsSecret":"s3cr3t"}}}}`,
+			"operator.kubearmor.com/support-bundle": "1",
+		}},
+		Spec: operatorv1.KubeArmorConfigSpec{
+			Values: &apiextensionsv1.JSON{Raw: []byte(`{"relay":{"tlsSecret":"s3cr3t"}}`)},
+		},
+	}).Build()
+	clientset := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"kubearmor.io/enforcer": "bpf"}}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubearmor-abcd", Namespace: "kubearmor", Labels: map[string]string{"kubearmor-app": "kubearmor"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "kubearmor"}}},
+		},
+	)
+
+	var buf bytes.Buffer
+	assert.NoError(t, (&Collector{Clientset: clientset, Client: k8sClient, Namespace: "kubearmor"}).Write(context.Background(), &buf))
+
+	files := map[string]string{}
+	gr, err := gzip.NewReader(&buf)
+	assert.NoError(t, err)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		files[hdr.Name] = string(data)
+	}
+
+	assert.Contains(t, files, "kubearmor-support-bundle/nodes.yaml")
+	assert.Contains(t, files["kubearmor-support-bundle/nodes.yaml"], "kubearmor.io/enforcer: bpf")
+	assert.Contains(t, files, "kubearmor-support-bundle/events.txt")
+	assert.Contains(t, files, "kubearmor-support-bundle/logs/kubearmor-abcd/kubearmor.log")
+	assert.Contains(t, files["kubearmor-support-bundle/kubearmorconfigs.yaml"], "tlsSecret: <redacted>")
+	assert.NotContains(t, files["kubearmor-support-bundle/kubearmorconfigs.yaml"], "s3cr3t")
+	assert.NotContains(t, files["kubearmor-support-bundle/kubearmorconfigs.yaml"], corev1.LastAppliedConfigAnnotation)
+	assert.Contains(t, files["kubearmor-support-bundle/kubearmorconfigs.yaml"], "operator.kubearmor.com/support-bundle")
+}